@@ -16,4 +16,5 @@ require (
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b
 	google.golang.org/grpc v1.26.0
+	google.golang.org/protobuf v1.23.0
 )
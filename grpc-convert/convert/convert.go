@@ -0,0 +1,215 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpfile"
+	"github.com/bradleyjkemp/grpc-tools/internal/har"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type Format string
+
+const (
+	// FormatJSON writes each RPC as a single JSON object per line (the default grpc-dump format)
+	FormatJSON Format = "json"
+	// FormatBinary writes the binary grpc-dump format
+	FormatBinary Format = "binary"
+	// FormatHAR writes a HAR (HTTP Archive) document
+	FormatHAR Format = "har"
+)
+
+type options struct {
+	format     Format
+	index      io.Writer
+	inputIndex string
+	selector   dumpfile.Selector
+}
+
+type Option func(*options)
+
+// WithFormat sets the format to convert to (FormatJSON by default)
+func WithFormat(format Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithIndex writes an index of the position of each RPC in the output.
+// Only supported by FormatBinary.
+func WithIndex(index io.Writer) Option {
+	return func(o *options) {
+		o.index = index
+	}
+}
+
+// WithInputIndex uses the index of a binary input dump to only read the selected RPCs
+func WithInputIndex(indexPath string) Option {
+	return func(o *options) {
+		o.inputIndex = indexPath
+	}
+}
+
+// WithSelector only converts the RPCs matching the selector
+func WithSelector(selector dumpfile.Selector) Option {
+	return func(o *options) {
+		o.selector = selector
+	}
+}
+
+// Run converts the dump at inputPath (in any format) and writes it to output
+func Run(inputPath string, output io.Writer, protoRoots, protoDescriptors string, opts ...Option) error {
+	o := &options{
+		format: FormatJSON,
+	}
+	for _, option := range opts {
+		option(o)
+	}
+
+	var write func(rpc *internal.RPC) error
+	closeOutput := func() error { return nil }
+	switch o.format {
+	case FormatJSON:
+		if o.index != nil {
+			return fmt.Errorf("an index can only be written for the %s format", FormatBinary)
+		}
+		encoder := json.NewEncoder(output)
+		write = func(rpc *internal.RPC) error {
+			return encoder.Encode(rpc)
+		}
+	case FormatBinary:
+		var index *dumpfile.IndexWriter
+		if o.index != nil {
+			index = dumpfile.NewIndexWriter(o.index)
+		}
+		w, err := dumpfile.NewBinaryWriter(output, index)
+		if err != nil {
+			return err
+		}
+		write = w.Write
+	case FormatHAR:
+		if o.index != nil {
+			return fmt.Errorf("an index can only be written for the %s format", FormatBinary)
+		}
+		w := har.NewWriter(output)
+		write = w.Write
+		closeOutput = w.Close
+	default:
+		return fmt.Errorf("unknown output format %q", o.format)
+	}
+
+	var resolvers []proto_decoder.MessageResolver
+	if protoRoots != "" {
+		r, err := proto_decoder.NewFileResolver(strings.Split(protoRoots, ",")...)
+		if err != nil {
+			return err
+		}
+		resolvers = append(resolvers, r)
+	}
+	if protoDescriptors != "" {
+		r, err := proto_decoder.NewDescriptorResolver(strings.Split(protoDescriptors, ",")...)
+		if err != nil {
+			return err
+		}
+		resolvers = append(resolvers, r)
+	}
+	encoder := proto_decoder.NewEncoder(resolvers...)
+	logger := logrus.New()
+	decoder := proto_decoder.NewDecoder(logger, resolvers...)
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	err = readRPCs(input, o, func(rpc *internal.RPC) error {
+		if o.format == FormatBinary {
+			// the binary format only stores raw messages so encode any that were only dumped in decoded form
+			for _, message := range rpc.Messages {
+				if message.RawMessage != nil {
+					continue
+				}
+				message.RawMessage, err = encoder.Encode(rpc.StreamName(), message)
+				if err != nil {
+					return errors.Wrapf(err, "failed to encode message for %s", rpc.StreamName())
+				}
+			}
+		}
+		if o.format != FormatBinary && len(resolvers) > 0 {
+			// decode any messages that were only dumped in raw form (e.g. in binary dumps) as grpc-dump would have
+			for _, message := range rpc.Messages {
+				if message.Message != nil || message.RawMessage == nil {
+					continue
+				}
+				decoded, err := decoder.Decode(rpc.StreamName(), message)
+				if err != nil {
+					logger.WithError(err).Warnf("Failed to decode message for %s", rpc.StreamName())
+					continue
+				}
+				message.Message = decoded
+			}
+		}
+		return write(rpc)
+	})
+	if err != nil {
+		return err
+	}
+	return closeOutput()
+}
+
+func readRPCs(input *os.File, o *options, f func(rpc *internal.RPC) error) error {
+	if o.inputIndex != "" {
+		indexFile, err := os.Open(o.inputIndex)
+		if err != nil {
+			return err
+		}
+		defer indexFile.Close()
+		index, err := dumpfile.ReadIndex(indexFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read input index")
+		}
+		selected, err := index.Select(o.selector)
+		if err != nil {
+			return err
+		}
+		for _, entry := range selected {
+			rpc, err := dumpfile.ReadAt(input, entry)
+			if err != nil {
+				return err
+			}
+			if err := f(rpc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	reader := dumpfile.NewReader(input)
+	for {
+		rpc, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		matched, err := o.selector.Matches(rpc.StreamName(), dumpfile.StartTime(rpc))
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		if err := f(rpc); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/grpc-convert/convert"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpfile"
+	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
+)
+
+func main() {
+	var (
+		input            = flag.String("input", "", "The gRPC dump to convert (in any grpc-dump output format).")
+		output           = flag.String("output", "", "File to write the converted dump to. Defaults to stdout.")
+		outputFormat     = flag.String("output_format", string(convert.FormatJSON), "Format to convert the dump to. Values are {json, binary, har}.")
+		indexFile        = flag.String("index_file", "", "File to write an index of the position of each RPC in the output to. Only supported by the binary output format.")
+		inputIndex       = flag.String("input_index", "", "Index of the binary input dump (as written by --index_file) used to read only the selected RPCs.")
+		method           = flag.String("method", "", "Only convert RPCs with a full method name (e.g. /foo.Service/Method) matching this glob pattern.")
+		from             = flag.String("from", "", "Only convert RPCs which started at or after this RFC 3339 time.")
+		to               = flag.String("to", "", "Only convert RPCs which started at or before this RFC 3339 time.")
+		protoRoots       = flag.String("proto_roots", "", "A comma separated list of directories to search for gRPC service definitions (used to encode messages without a raw_message and decode messages without a decoded form).")
+		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of proto descriptors to load gRPC service definitions from (used to encode messages without a raw_message and decode messages without a decoded form).")
+	)
+	flag.Parse()
+
+	err := run(*input, *output, *outputFormat, *indexFile, *inputIndex, *method, *from, *to, *protoRoots, *protoDescriptors)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func run(input, output, outputFormat, indexFile, inputIndex, method, from, to, protoRoots, protoDescriptors string) error {
+	if input == "" {
+		return fmt.Errorf("--input is required")
+	}
+	selector := dumpfile.Selector{Method: method}
+	var err error
+	if from != "" {
+		if selector.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		if selector.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return err
+		}
+	}
+
+	opts := []convert.Option{
+		convert.WithFormat(convert.Format(outputFormat)),
+		convert.WithSelector(selector),
+	}
+	if inputIndex != "" {
+		opts = append(opts, convert.WithInputIndex(inputIndex))
+	}
+	if indexFile != "" {
+		index, err := os.Create(indexFile)
+		if err != nil {
+			return err
+		}
+		defer index.Close()
+		opts = append(opts, convert.WithIndex(index))
+	}
+
+	out := os.Stdout
+	if output != "" {
+		out, err = os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	buffered := bufio.NewWriter(out)
+	if err := convert.Run(input, buffered, protoRoots, protoDescriptors, opts...); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
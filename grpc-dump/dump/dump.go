@@ -1,14 +1,176 @@
 package dump
 
 import (
+	"fmt"
+	"io"
+	"strings"
+
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
+	"github.com/bradleyjkemp/grpc-tools/internal"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/redact"
 	"github.com/sirupsen/logrus"
-	"io"
-	"strings"
+	"google.golang.org/grpc"
 )
 
+type Format string
+
+const (
+	// FormatJSON dumps each RPC as a single JSON object once it has finished
+	FormatJSON Format = "json"
+	// FormatEvents dumps a JSON object for each event (start, message, headers, trailers, end)
+	// as soon as it happens. Events for the same RPC share a stream_id.
+	FormatEvents Format = "events"
+	// FormatBinary dumps each RPC as a length delimited protobuf message once it has finished.
+	// Decoded messages aren't included so this is much smaller and faster to read.
+	FormatBinary Format = "binary"
+	// FormatHAR dumps RPCs as the entries of a HAR (HTTP Archive) document
+	// which is finished when the proxy is stopped (e.g. by SIGINT).
+	FormatHAR Format = "har"
+)
+
+type options struct {
+	format    Format
+	filters   filters
+	redactor  *redact.Redactor
+	index     io.Writer
+	append    bool
+	dumped    func(rpc *internal.RPC)
+	logOutput io.Writer
+	configErr error
+}
+
+// logger returns a logger writing to the log output (stderr by default)
+func (o *options) logger() *logrus.Logger {
+	logger := logrus.New()
+	if o.logOutput != nil {
+		logger.SetOutput(o.logOutput)
+	}
+	return logger
+}
+
+type Option func(*options)
+
+// WithFormat sets the format RPCs are written to the output in (FormatJSON by default)
+func WithFormat(format Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithInclude only dumps RPCs matching the filter expression.
+// If used multiple times, RPCs matching any of the expressions are dumped.
+// See the README for the filter syntax.
+func WithInclude(expression string) Option {
+	return func(o *options) {
+		f, err := parseFilter(expression)
+		if err != nil {
+			o.configErr = err
+			return
+		}
+		o.filters.include = append(o.filters.include, f)
+	}
+}
+
+// WithExclude doesn't dump RPCs matching the filter expression
+func WithExclude(expression string) Option {
+	return func(o *options) {
+		f, err := parseFilter(expression)
+		if err != nil {
+			o.configErr = err
+			return
+		}
+		o.filters.exclude = append(o.filters.exclude, f)
+	}
+}
+
+// WithRedactor redacts metadata and message fields before they are dumped
+func WithRedactor(redactor *redact.Redactor) Option {
+	return func(o *options) {
+		o.redactor = redactor
+	}
+}
+
+// WithIndex writes an index of the position of each RPC in the dump.
+// Only supported by FormatBinary.
+func WithIndex(index io.Writer) Option {
+	return func(o *options) {
+		o.index = index
+	}
+}
+
+// WithAppend appends to an output which already contains a dump in the same format
+// (e.g. so that the binary dump header isn't written again).
+// Not supported by FormatHAR.
+func WithAppend() Option {
+	return func(o *options) {
+		o.append = true
+	}
+}
+
+// WithDumpedCallback calls f with each RPC after it has been dumped.
+// Not supported by FormatEvents.
+func WithDumpedCallback(f func(rpc *internal.RPC)) Option {
+	return func(o *options) {
+		o.dumped = f
+	}
+}
+
+// WithLogOutput writes logs (e.g. warnings about RPCs that can't be decoded) to w instead of stderr.
+// Use grpc_proxy.WithLogOutput to do the same for the proxy's logs.
+func WithLogOutput(w io.Writer) Option {
+	return func(o *options) {
+		o.logOutput = w
+	}
+}
+
+// NewInterceptor returns an interceptor which dumps RPCs to output and a function to call
+// once the proxy has stopped to finish writing the output. RPCs still in progress
+// are dumped before the output is finished and any later RPCs aren't dumped.
+// This is used by Run but is also exported so that other tools can dump RPCs.
+func NewInterceptor(output io.Writer, decoder proto_decoder.MessageDecoder, dumpOptions ...Option) (grpc.StreamServerInterceptor, func() error, error) {
+	o := &options{
+		format: FormatJSON,
+	}
+	for _, option := range dumpOptions {
+		option(o)
+	}
+	if o.configErr != nil {
+		return nil, nil, o.configErr
+	}
+	switch o.format {
+	case FormatJSON, FormatEvents, FormatBinary, FormatHAR:
+	default:
+		return nil, nil, fmt.Errorf("unknown output format %q", o.format)
+	}
+	if o.index != nil && (o.format != FormatBinary || o.append) {
+		return nil, nil, fmt.Errorf("an index can only be written for the %s output format (and not when appending)", FormatBinary)
+	}
+	if o.format == FormatEvents && o.filters.needsCompleteRPC() {
+		return nil, nil, fmt.Errorf("status and message filters can't be used with the %s output format", FormatEvents)
+	}
+	if o.append && o.format == FormatHAR {
+		return nil, nil, fmt.Errorf("can't append to %s output", FormatHAR)
+	}
+	if o.dumped != nil && o.format == FormatEvents {
+		return nil, nil, fmt.Errorf("dumped RPCs can't be observed with the %s output format", FormatEvents)
+	}
+
+	// TODO: unify this logger with the one provided by grpc_proxy?
+	interceptor, closeOutput, err := dumpInterceptor(o.logger(), output, decoder, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracker := &rpcTracker{}
+	return tracker.intercept(interceptor), tracker.close(closeOutput), nil
+}
+
 func Run(output io.Writer, protoRoots, protoDescriptors string, proxyConfig ...grpc_proxy.Configurator) error {
+	return RunWithOptions(output, protoRoots, protoDescriptors, nil, proxyConfig...)
+}
+
+// RunWithOptions is like Run but dumps RPCs using the dump options (e.g. in a different format)
+func RunWithOptions(output io.Writer, protoRoots, protoDescriptors string, dumpOptions []Option, proxyConfig ...grpc_proxy.Configurator) error {
 	var resolvers []proto_decoder.MessageResolver
 	if protoRoots != "" {
 		r, err := proto_decoder.NewFileResolver(strings.Split(protoRoots, ",")...)
@@ -25,12 +187,19 @@ func Run(output io.Writer, protoRoots, protoDescriptors string, proxyConfig ...g
 		resolvers = append(resolvers, r)
 	}
 
-	// TODO: unify this logger with the one provided by grpc_proxy?
-	logger := logrus.New()
+	o := &options{}
+	for _, option := range dumpOptions {
+		option(o)
+	}
+	decoder := proto_decoder.NewDecoder(o.logger(), resolvers...)
+	interceptor, closeOutput, err := NewInterceptor(output, decoder, dumpOptions...)
+	if err != nil {
+		return err
+	}
 	opts := append(
 		proxyConfig,
-		grpc_proxy.WithInterceptor(
-			dumpInterceptor(logger, output, proto_decoder.NewDecoder(logger, resolvers...))),
+		grpc_proxy.WithInterceptor(interceptor),
+		grpc_proxy.WithMessageDecoder(decoder),
 	)
 	proxy, err := grpc_proxy.New(
 		opts...,
@@ -39,5 +208,9 @@ func Run(output io.Writer, protoRoots, protoDescriptors string, proxyConfig ...g
 		return err
 	}
 
-	return proxy.Start()
+	err = proxy.Start()
+	if closeErr := closeOutput(); err == nil {
+		err = closeErr
+	}
+	return err
 }
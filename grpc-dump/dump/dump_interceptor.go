@@ -1,14 +1,22 @@
 package dump
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
 	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpfile"
+	"github.com/bradleyjkemp/grpc-tools/internal/har"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_descriptor"
+	"github.com/bradleyjkemp/grpc-tools/internal/redact"
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
@@ -40,46 +48,367 @@ func (p *pbm) MarshalJSON() ([]byte, error) {
 		})
 }
 
-func dumpInterceptor(logger logrus.FieldLogger, output io.Writer, decoder proto_decoder.MessageDecoder) grpc.StreamServerInterceptor {
-	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		dss := &recordedServerStream{ServerStream: ss}
-		rpcErr := handler(srv, dss)
-		var rpcStatus *internal.Status
-		if rpcErr != nil {
-			grpcStatus, _ := status.FromError(rpcErr)
-			rpcStatus = &internal.Status{
-				Code:    grpcStatus.Code().String(),
-				Message: grpcStatus.Message(),
+// jsonLineWriter writes values as newline separated JSON,
+// making sure that concurrently written lines don't interleave.
+type jsonLineWriter struct {
+	sync.Mutex
+	logger logrus.FieldLogger
+	output io.Writer
+}
+
+func (w *jsonLineWriter) write(v interface{}) {
+	dump, err := json.Marshal(v)
+	if err != nil {
+		w.logger.WithError(err).Fatal("Failed to marshal rpc")
+	}
+	w.Lock()
+	defer w.Unlock()
+	fmt.Fprintln(w.output, string(dump))
+}
+
+// binaryWriter writes RPCs in the binary dump format,
+// making sure that concurrently written RPCs don't interleave.
+type binaryWriter struct {
+	sync.Mutex
+	logger logrus.FieldLogger
+	output *dumpfile.BinaryWriter
+}
+
+func (w *binaryWriter) write(rpc *internal.RPC) {
+	w.Lock()
+	defer w.Unlock()
+	if err := w.output.Write(rpc); err != nil {
+		w.logger.WithError(err).Error("Failed to write rpc")
+	}
+}
+
+// harWriter writes RPCs as HAR entries
+type harWriter struct {
+	logger logrus.FieldLogger
+	output *har.Writer
+}
+
+func (w *harWriter) write(rpc *internal.RPC) {
+	if err := w.output.Write(rpc); err != nil {
+		w.logger.WithError(err).Error("Failed to write rpc")
+	}
+}
+
+// dumpInterceptor returns the interceptor and a function to call
+// once the proxy has stopped to finish writing the output.
+func dumpInterceptor(logger logrus.FieldLogger, output io.Writer, decoder proto_decoder.MessageDecoder, opts *options) (grpc.StreamServerInterceptor, func() error, error) {
+	out := &jsonLineWriter{logger: logger, output: output}
+	writeRPC := func(rpc *internal.RPC) {
+		out.write(rpc)
+	}
+	closeOutput := func() error { return nil }
+	switch opts.format {
+	case FormatEvents:
+		interceptor, pending := eventsInterceptor(logger, out, decoder, opts.filters, opts.redactor)
+		closeOutput = func() error {
+			pending.Wait()
+			return nil
+		}
+		return interceptor, closeOutput, nil
+	case FormatBinary:
+		var index *dumpfile.IndexWriter
+		if opts.index != nil {
+			index = dumpfile.NewIndexWriter(opts.index)
+		}
+		w := dumpfile.NewBinaryAppender(output)
+		if !opts.append {
+			var err error
+			w, err = dumpfile.NewBinaryWriter(output, index)
+			if err != nil {
+				return nil, nil, err
 			}
 		}
+		writeRPC = (&binaryWriter{logger: logger, output: w}).write
+	case FormatHAR:
+		w := har.NewWriter(output)
+		writeRPC = (&harWriter{logger: logger, output: w}).write
+		closeOutput = w.Close
+	}
+
+	// decoded messages aren't written in the binary format so only decode them if needed
+	decode := opts.format != FormatBinary || opts.filters.needsMessages() || opts.redactor.RedactsFields()
+
+	dumpRPC := func(fullMethod string, rpc *internal.RPC) {
+		if rpc.Mirror != nil {
+			compareMirror(logger, fullMethod, rpc, rpc.Mirror)
+		}
+
+		decoded := make([]*dynamic.Message, len(rpc.Messages))
+		for i := range rpc.Messages {
+			if decode {
+				decoded[i] = decodeMessage(logger, decoder, fullMethod, rpc.Messages[i])
+			}
+		}
+		if !opts.filters.match(rpc) {
+			return
+		}
+
+		// redact after filtering so that filters can match the original values
+		for i := range rpc.Messages {
+			redactMessage(logger, opts.redactor, rpc.Messages[i], decoded[i])
+		}
+		rpc.Metadata = opts.redactor.Metadata(rpc.Metadata)
+		rpc.MetadataRespHeaders = opts.redactor.Metadata(rpc.MetadataRespHeaders)
+		rpc.MetadataRespTrailers = opts.redactor.Metadata(rpc.MetadataRespTrailers)
+		if rpc.Mirror != nil {
+			redactMirror(logger, decoder, opts.redactor, fullMethod, rpc.Mirror, decode)
+		}
+		writeRPC(rpc)
+		if opts.dumped != nil {
+			opts.dumped(rpc)
+		}
+	}
+
+	// mirrored RPCs are dumped once the mirror has responded so wait for them before closing the output
+	var pending sync.WaitGroup
+	closeWriter := closeOutput
+	closeOutput = func() error {
+		pending.Wait()
+		return closeWriter()
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		recorder := &rpcRecorder{}
+		rpcErr := handler(srv, &recordedServerStream{ServerStream: ss, recorder: recorder})
 
 		fullMethod := strings.Split(info.FullMethod, "/")
 		md, _ := metadata.FromIncomingContext(ss.Context())
-		dss.Lock()
-		defer dss.Unlock()
+		recorder.Lock()
+		defer recorder.Unlock()
 		rpc := internal.RPC{
 			Service:              fullMethod[1],
 			Method:               fullMethod[2],
-			Messages:             dss.events,
-			Status:               rpcStatus,
+			Messages:             recorder.events,
+			Status:               rpcStatus(rpcErr),
 			Metadata:             md,
-			MetadataRespHeaders:  dss.headers,
-			MetadataRespTrailers: dss.trailers,
+			MetadataRespHeaders:  recorder.headers,
+			MetadataRespTrailers: recorder.trailers,
+			Route:                grpc_proxy.ForwardedRoute(ss.Context()),
+		}
+		if clientCert := grpc_proxy.ClientCertificate(ss.Context()); clientCert != nil {
+			rpc.ClientCertificate = internal.NewCertificate(clientCert)
 		}
 
-		var err error
-		for i := range rpc.Messages {
-			msg, err := decoder.Decode(info.FullMethod, rpc.Messages[i])
-			if err != nil {
-				logger.WithError(err).Warn("Failed to decode message")
-			}
-			rpc.Messages[i].Message = &pbm{msg}
+		if grpc_proxy.Mirrored(ss.Context()) {
+			// don't hold up the client while the mirror catches up
+			pending.Add(1)
+			go func() {
+				defer pending.Done()
+				rpc.Mirror = grpc_proxy.WaitForMirror(ss.Context())
+				dumpRPC(info.FullMethod, &rpc)
+			}()
+			return rpcErr
+		}
+		dumpRPC(info.FullMethod, &rpc)
+		return rpcErr
+	}, closeOutput, nil
+}
+
+// rpcTracker stops RPCs being dumped once the output is being closed (so that nothing is
+// written after e.g. the end of a HAR document) and waits for the RPCs already being dumped
+type rpcTracker struct {
+	sync.Mutex
+	closed bool
+	rpcs   sync.WaitGroup
+}
+
+func (t *rpcTracker) intercept(interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		t.Lock()
+		if t.closed {
+			t.Unlock()
+			return handler(srv, ss)
+		}
+		t.rpcs.Add(1)
+		t.Unlock()
+		defer t.rpcs.Done()
+		return interceptor(srv, ss, info, handler)
+	}
+}
+
+func (t *rpcTracker) close(closeOutput func() error) func() error {
+	return func() error {
+		t.Lock()
+		t.closed = true
+		t.Unlock()
+		t.rpcs.Wait()
+		return closeOutput()
+	}
+}
+
+// eventsInterceptor dumps each part of an RPC as soon as it happens so that
+// long-lived streams are visible (and don't have to be kept in memory).
+// Filters are evaluated when the RPC starts so can't depend on its status or messages.
+// The returned WaitGroup tracks mirror events which are written once the mirror has responded.
+func eventsInterceptor(logger logrus.FieldLogger, out *jsonLineWriter, decoder proto_decoder.MessageDecoder, filters filters, redactor *redact.Redactor) (grpc.StreamServerInterceptor, *sync.WaitGroup) {
+	pending := &sync.WaitGroup{}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		fullMethod := strings.Split(info.FullMethod, "/")
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		if !filters.match(&internal.RPC{Service: fullMethod[1], Method: fullMethod[2], Metadata: md}) {
+			return handler(srv, ss)
+		}
+
+		recorder := &eventRecorder{
+			logger:     logger,
+			out:        out,
+			decoder:    decoder,
+			redactor:   redactor,
+			fullMethod: info.FullMethod,
+			streamID:   newStreamID(),
+			mirrored:   grpc_proxy.Mirrored(ss.Context()),
+		}
+
+		start := &internal.StreamEvent{
+			Event:    internal.StreamStart,
+			Service:  fullMethod[1],
+			Method:   fullMethod[2],
+			Metadata: redactor.Metadata(md),
 		}
-		dump, err := json.Marshal(rpc)
-		if err != nil {
-			logger.WithError(err).Fatal("Failed to marshal rpc")
+		if clientCert := grpc_proxy.ClientCertificate(ss.Context()); clientCert != nil {
+			start.ClientCertificate = internal.NewCertificate(clientCert)
+		}
+		recorder.write(start)
+
+		rpcErr := handler(srv, &recordedServerStream{ServerStream: ss, recorder: recorder})
+		end := &internal.StreamEvent{
+			Event:  internal.StreamEnd,
+			Status: rpcStatus(rpcErr),
+			Route:  grpc_proxy.ForwardedRoute(ss.Context()),
+		}
+		recorder.write(end)
+		if recorder.mirrored {
+			pending.Add(1)
+			go func() {
+				defer pending.Done()
+				recorder.recordMirror(end.Status, grpc_proxy.WaitForMirror(ss.Context()))
+			}()
 		}
-		fmt.Fprintln(output, string(dump))
 		return rpcErr
+	}, pending
+}
+
+type eventRecorder struct {
+	logger     logrus.FieldLogger
+	out        *jsonLineWriter
+	decoder    proto_decoder.MessageDecoder
+	redactor   *redact.Redactor
+	fullMethod string
+	streamID   string
+
+	// the server's messages are kept to compare with the mirror's
+	mirrored       bool
+	serverMessages []*internal.Message
+	sync.Mutex
+}
+
+func (r *eventRecorder) write(event *internal.StreamEvent) {
+	event.StreamID = r.streamID
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	r.out.write(event)
+}
+
+func (r *eventRecorder) recordMessage(message *internal.Message) {
+	if r.mirrored && message.MessageOrigin == internal.ServerMessage {
+		r.Lock()
+		r.serverMessages = append(r.serverMessages, &internal.Message{
+			MessageOrigin: message.MessageOrigin,
+			RawMessage:    message.RawMessage,
+		})
+		r.Unlock()
 	}
+	decoded := decodeMessage(r.logger, r.decoder, r.fullMethod, message)
+	redactMessage(r.logger, r.redactor, message, decoded)
+	r.write(&internal.StreamEvent{
+		Event:     internal.StreamMessage,
+		Timestamp: message.Timestamp,
+		Message:   message,
+	})
+}
+
+func (r *eventRecorder) recordHeaders(headers metadata.MD) {
+	r.write(&internal.StreamEvent{
+		Event:    internal.StreamHeaders,
+		Metadata: r.redactor.Metadata(headers),
+	})
+}
+
+func (r *eventRecorder) recordTrailers(trailers metadata.MD) {
+	r.write(&internal.StreamEvent{
+		Event:    internal.StreamTrailer,
+		Metadata: r.redactor.Metadata(trailers),
+	})
+}
+
+func (r *eventRecorder) recordMirror(status *internal.Status, mirror *internal.Mirror) {
+	if mirror == nil {
+		return
+	}
+	r.Lock()
+	compareMirror(r.logger, r.fullMethod, &internal.RPC{Messages: r.serverMessages, Status: status}, mirror)
+	r.Unlock()
+	redactMirror(r.logger, r.decoder, r.redactor, r.fullMethod, mirror, true)
+	r.write(&internal.StreamEvent{
+		Event:  internal.StreamMirror,
+		Mirror: mirror,
+	})
+}
+
+// compareMirror records (and warns about) how the mirror's response differs from the RPC's
+func compareMirror(logger logrus.FieldLogger, fullMethod string, rpc *internal.RPC, mirror *internal.Mirror) {
+	mirror.Differences = internal.MirrorDifferences(rpc, mirror)
+	if len(mirror.Differences) > 0 {
+		logger.WithField("method", fullMethod).Warnf("Mirror response differs: %s", strings.Join(mirror.Differences, ", "))
+	}
+}
+
+func redactMirror(logger logrus.FieldLogger, decoder proto_decoder.MessageDecoder, redactor *redact.Redactor, fullMethod string, mirror *internal.Mirror, decode bool) {
+	for _, message := range mirror.Messages {
+		var decoded *dynamic.Message
+		if decode {
+			decoded = decodeMessage(logger, decoder, fullMethod, message)
+		}
+		redactMessage(logger, redactor, message, decoded)
+	}
+	mirror.MetadataRespHeaders = redactor.Metadata(mirror.MetadataRespHeaders)
+	mirror.MetadataRespTrailers = redactor.Metadata(mirror.MetadataRespTrailers)
+}
+
+func decodeMessage(logger logrus.FieldLogger, decoder proto_decoder.MessageDecoder, fullMethod string, message *internal.Message) *dynamic.Message {
+	msg, err := decoder.Decode(fullMethod, message)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to decode message")
+	}
+	message.Message = &pbm{msg}
+	return msg
+}
+
+func redactMessage(logger logrus.FieldLogger, redactor *redact.Redactor, message *internal.Message, decoded *dynamic.Message) {
+	if err := redactor.DumpedMessage(message, decoded); err != nil {
+		logger.WithError(err).Warn("Failed to redact message")
+	}
+}
+
+func rpcStatus(rpcErr error) *internal.Status {
+	if rpcErr == nil {
+		return nil
+	}
+	grpcStatus, _ := status.FromError(rpcErr)
+	return &internal.Status{
+		Code:    grpcStatus.Code().String(),
+		Message: grpcStatus.Message(),
+	}
+}
+
+func newStreamID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
 }
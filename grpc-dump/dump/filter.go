@@ -0,0 +1,247 @@
+package dump
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+// A filter is a list of terms, separated by "&&", that must all match an RPC.
+// Each term is a field (service, method, authority, status, metadata.<key> or message.<field path>)
+// optionally followed by an operator: = (glob), != (negated glob) or ~= (regex).
+// A field without an operator matches if the field is present.
+type filter []*filterTerm
+
+type filterTerm struct {
+	field   string
+	path    []string // for metadata and message fields
+	negate  bool
+	matches func(string) bool
+}
+
+var termPattern = regexp.MustCompile(`^\s*([A-Za-z0-9_.:\-]+)\s*(?:(!=|~=|=)(.*))?$`)
+
+func parseFilter(expression string) (filter, error) {
+	var f filter
+	for _, term := range strings.Split(expression, "&&") {
+		parts := termPattern.FindStringSubmatch(term)
+		if parts == nil {
+			return nil, fmt.Errorf("invalid filter term %q", strings.TrimSpace(term))
+		}
+		t := &filterTerm{}
+		fieldPath := strings.Split(parts[1], ".")
+		t.field, t.path = fieldPath[0], fieldPath[1:]
+		switch t.field {
+		case "service", "method", "authority", "status":
+			if len(t.path) > 0 {
+				return nil, fmt.Errorf("invalid filter field %q", parts[1])
+			}
+		case "metadata", "message":
+			if len(t.path) == 0 {
+				return nil, fmt.Errorf("filter field %q must be followed by a key e.g. %s.name", t.field, t.field)
+			}
+		default:
+			return nil, fmt.Errorf("unknown filter field %q", parts[1])
+		}
+		if t.field == "metadata" {
+			// metadata keys are always lowercase and may contain dots
+			t.path = []string{strings.ToLower(strings.Join(t.path, "."))}
+		}
+
+		operator, value := parts[2], strings.TrimSpace(parts[3])
+		switch operator {
+		case "":
+			t.matches = func(string) bool { return true }
+		case "=", "!=":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %v", value, err)
+			}
+			t.negate = operator == "!="
+			t.matches = func(s string) bool {
+				matched, _ := path.Match(value, s)
+				return matched
+			}
+		case "~=":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %q: %v", value, err)
+			}
+			t.matches = re.MatchString
+		}
+		f = append(f, t)
+	}
+	return f, nil
+}
+
+// ParseFilter parses a filter expression (see the README) into a function
+// returning whether an RPC matches it, e.g. to filter RPCs passed to WithDumpedCallback
+func ParseFilter(expression string) (func(rpc *internal.RPC) bool, error) {
+	f, err := parseFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	return f.match, nil
+}
+
+// needsCompleteRPC returns true if the filter can only be evaluated once the RPC has finished
+func (f filter) needsCompleteRPC() bool {
+	for _, t := range f {
+		if t.field == "status" || t.field == "message" {
+			return true
+		}
+	}
+	return false
+}
+
+func (f filter) match(rpc *internal.RPC) bool {
+	for _, t := range f {
+		if !t.match(rpc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *filterTerm) match(rpc *internal.RPC) bool {
+	var values []string
+	switch t.field {
+	case "service":
+		values = []string{rpc.Service}
+	case "method":
+		values = []string{rpc.Method, rpc.StreamName()}
+	case "authority":
+		values = rpc.Metadata.Get(":authority")
+	case "status":
+		if rpc.Status == nil {
+			values = []string{"OK"}
+		} else {
+			values = []string{rpc.Status.Code}
+		}
+	case "metadata":
+		values = rpc.Metadata.Get(t.path[0])
+	case "message":
+		for _, message := range rpc.Messages {
+			if decoded, ok := message.Message.(*pbm); ok && decoded.Message != nil {
+				values = append(values, fieldValues(decoded.Message, t.path)...)
+			}
+		}
+	}
+
+	matched := false
+	for _, value := range values {
+		if t.matches(value) {
+			matched = true
+			break
+		}
+	}
+	if t.negate {
+		return !matched
+	}
+	return matched
+}
+
+// fieldValues finds the values of a (possibly nested) field in a message.
+// Fields can be referred to by name, JSON name or number.
+func fieldValues(message *dynamic.Message, fieldPath []string) []string {
+	var field *desc.FieldDescriptor
+	for _, f := range message.GetMessageDescriptor().GetFields() {
+		if f.GetName() == fieldPath[0] || f.GetJSONName() == fieldPath[0] || fmt.Sprint(f.GetNumber()) == fieldPath[0] {
+			field = f
+			break
+		}
+	}
+	if field == nil || !message.HasField(field) {
+		return nil
+	}
+
+	var values []interface{}
+	value := message.GetField(field)
+	switch {
+	case field.IsMap():
+		if len(fieldPath) < 2 {
+			return nil
+		}
+		for k, v := range value.(map[interface{}]interface{}) {
+			if fmt.Sprint(k) == fieldPath[1] {
+				values = append(values, v)
+			}
+		}
+		fieldPath = fieldPath[1:]
+	case field.IsRepeated():
+		values = value.([]interface{})
+	default:
+		values = []interface{}{value}
+	}
+
+	var result []string
+	for _, v := range values {
+		switch v := v.(type) {
+		case *dynamic.Message:
+			if len(fieldPath) > 1 {
+				result = append(result, fieldValues(v, fieldPath[1:])...)
+			}
+		default:
+			if len(fieldPath) > 1 {
+				continue
+			}
+			if enum := field.GetEnumType(); enum != nil {
+				if enumValue := enum.FindValueByNumber(v.(int32)); enumValue != nil {
+					result = append(result, enumValue.GetName())
+					continue
+				}
+			}
+			result = append(result, fmt.Sprint(v))
+		}
+	}
+	return result
+}
+
+// filters decides whether an RPC should be dumped
+type filters struct {
+	include []filter
+	exclude []filter
+}
+
+func (f filters) match(rpc *internal.RPC) bool {
+	included := len(f.include) == 0
+	for _, include := range f.include {
+		if include.match(rpc) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, exclude := range f.exclude {
+		if exclude.match(rpc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f filters) needsMessages() bool {
+	for _, filter := range append(f.include, f.exclude...) {
+		for _, t := range filter {
+			if t.field == "message" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f filters) needsCompleteRPC() bool {
+	for _, filter := range append(f.include, f.exclude...) {
+		if filter.needsCompleteRPC() {
+			return true
+		}
+	}
+	return false
+}
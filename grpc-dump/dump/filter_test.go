@@ -0,0 +1,96 @@
+package dump
+
+import (
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func testRPC(t *testing.T) *internal.RPC {
+	inner := builder.NewMessage("Inner").
+		AddField(builder.NewField("inner_value", builder.FieldTypeString()))
+	outer := builder.NewMessage("Outer").
+		AddField(builder.NewField("outer_value", builder.FieldTypeMessage(inner))).
+		AddField(builder.NewField("tags", builder.FieldTypeString()).SetRepeated())
+	md, err := builder.NewFile("test.proto").AddMessage(inner).AddMessage(outer).Build()
+	require.NoError(t, err)
+
+	innerMsg := dynamic.NewMessage(md.FindMessage("Inner"))
+	innerMsg.SetFieldByName("inner_value", "hello")
+	outerMsg := dynamic.NewMessage(md.FindMessage("Outer"))
+	outerMsg.SetFieldByName("outer_value", innerMsg)
+	outerMsg.SetFieldByName("tags", []string{"a", "b"})
+
+	return &internal.RPC{
+		Service: "pkg.Service",
+		Method:  "Get",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, Message: &pbm{outerMsg}},
+		},
+		Status: &internal.Status{Code: "NotFound"},
+		Metadata: metadata.MD{
+			":authority":    []string{"api.example.com:443"},
+			"authorization": []string{"Bearer token"},
+		},
+	}
+}
+
+func TestFilter(t *testing.T) {
+	rpc := testRPC(t)
+	cases := map[string]bool{
+		"service=pkg.*":                         true,
+		"service=other.*":                       false,
+		"method=Get":                            true,
+		"method=/pkg.Service/*":                 true,
+		"method~=^(Get|List)$":                  true,
+		"authority=*.example.com:*":             true,
+		"metadata.authorization":                true,
+		"metadata.x-missing":                    false,
+		"metadata.Authorization~=^Bearer ":      true,
+		"status=NotFound":                       true,
+		"status!=OK":                            true,
+		"message.outer_value.inner_value=hell*": true,
+		"message.outerValue.innerValue=other":   false,
+		"message.tags=b":                        true,
+		"service=pkg.* && status=OK":            false,
+		"service=pkg.* && message.tags":         true,
+	}
+	for expression, expected := range cases {
+		f, err := parseFilter(expression)
+		require.NoError(t, err, expression)
+		require.Equal(t, expected, f.match(rpc), expression)
+	}
+}
+
+func TestFilter_Invalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"unknown=foo",
+		"service.name=foo",
+		"metadata=foo",
+		"service=[",
+		"method~=(",
+	} {
+		_, err := parseFilter(expression)
+		require.Error(t, err, expression)
+	}
+}
+
+func TestFilters_IncludeExclude(t *testing.T) {
+	rpc := testRPC(t)
+	parse := func(expression string) filter {
+		f, err := parseFilter(expression)
+		require.NoError(t, err)
+		return f
+	}
+
+	require.True(t, filters{}.match(rpc))
+	require.True(t, filters{include: []filter{parse("service=other"), parse("method=Get")}}.match(rpc))
+	require.False(t, filters{include: []filter{parse("service=other")}}.match(rpc))
+	require.False(t, filters{exclude: []filter{parse("method=Get")}}.match(rpc))
+	require.False(t, filters{include: []filter{parse("method=Get")}, exclude: []filter{parse("status=NotFound")}}.match(rpc))
+}
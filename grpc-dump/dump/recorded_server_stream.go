@@ -10,33 +10,31 @@ import (
 	"google.golang.org/grpc/metadata"
 )
 
+// streamRecorder is notified of everything sent and received on a stream
+type streamRecorder interface {
+	recordMessage(message *internal.Message)
+	recordHeaders(headers metadata.MD)
+	recordTrailers(trailers metadata.MD)
+}
+
 // recordedServerStream wraps a grpc.ServerStream and allows the dump interceptor to record all sent/received messages
 type recordedServerStream struct {
-	sync.Mutex
 	grpc.ServerStream
-	events   []*internal.Message
-	headers  metadata.MD
-	trailers metadata.MD
+	recorder streamRecorder
 }
 
 func (ss *recordedServerStream) SendHeader(headers metadata.MD) error {
-	ss.Lock()
-	ss.headers = metadata.Join(ss.headers, headers)
-	ss.Unlock()
+	ss.recorder.recordHeaders(headers)
 	return ss.ServerStream.SendHeader(headers)
 }
 
 func (ss *recordedServerStream) SetHeader(headers metadata.MD) error {
-	ss.Lock()
-	ss.headers = metadata.Join(ss.headers, headers)
-	ss.Unlock()
+	ss.recorder.recordHeaders(headers)
 	return ss.ServerStream.SetHeader(headers)
 }
 
 func (ss *recordedServerStream) SetTrailer(trailers metadata.MD) {
-	ss.Lock()
-	ss.trailers = trailers
-	ss.Unlock()
+	ss.recorder.recordTrailers(trailers)
 	ss.ServerStream.SetTrailer(trailers)
 }
 
@@ -46,13 +44,11 @@ func (ss *recordedServerStream) SendMsg(m interface{}) error {
 		// although the message is nil here, we actually want to save it as the empty message ("")
 		message = []byte{}
 	}
-	ss.Lock()
-	ss.events = append(ss.events, &internal.Message{
+	ss.recorder.recordMessage(&internal.Message{
 		MessageOrigin: internal.ServerMessage,
 		RawMessage:    message,
 		Timestamp:     time.Now(),
 	})
-	ss.Unlock()
 	return ss.ServerStream.SendMsg(m)
 }
 
@@ -63,12 +59,36 @@ func (ss *recordedServerStream) RecvMsg(m interface{}) error {
 	}
 	// now m is populated
 	message := m.(*[]byte)
-	ss.Lock()
-	ss.events = append(ss.events, &internal.Message{
+	ss.recorder.recordMessage(&internal.Message{
 		MessageOrigin: internal.ClientMessage,
 		RawMessage:    *message,
 		Timestamp:     time.Now(),
 	})
-	ss.Unlock()
 	return nil
 }
+
+// rpcRecorder saves everything on a stream so that the whole RPC can be dumped once it has finished
+type rpcRecorder struct {
+	sync.Mutex
+	events   []*internal.Message
+	headers  metadata.MD
+	trailers metadata.MD
+}
+
+func (r *rpcRecorder) recordMessage(message *internal.Message) {
+	r.Lock()
+	r.events = append(r.events, message)
+	r.Unlock()
+}
+
+func (r *rpcRecorder) recordHeaders(headers metadata.MD) {
+	r.Lock()
+	r.headers = metadata.Join(r.headers, headers)
+	r.Unlock()
+}
+
+func (r *rpcRecorder) recordTrailers(trailers metadata.MD) {
+	r.Lock()
+	r.trailers = trailers
+	r.Unlock()
+}
@@ -1,26 +1,123 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-dump/dump"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
+	"github.com/bradleyjkemp/grpc-tools/internal/redact"
+	"github.com/bradleyjkemp/grpc-tools/internal/tui"
 	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
+	"io/ioutil"
 	"os"
+	"strings"
 )
 
+// repeatedFlag collects the values of a flag that can be set multiple times
+type repeatedFlag []string
+
+func (r *repeatedFlag) String() string {
+	return strings.Join(*r, ", ")
+}
+
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
 func main() {
 	var (
 		protoRoots       = flag.String("proto_roots", "", "A comma separated list of directories to search for gRPC service definitions.")
 		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of proto descriptors to load gRPC service definitions from.")
+		outputFormat     = flag.String("output_format", string(dump.FormatJSON), "Format to dump RPCs in. Values are {json, events, binary, har}: json writes each RPC once it has finished, events writes each message as it happens, binary writes each RPC in a compact binary format, har writes a HAR document (finished when grpc-dump is stopped).")
+		indexFile        = flag.String("index_file", "", "File to write an index of the position of each RPC in the dump to. Only supported by the binary output format.")
+		showTUI          = flag.Bool("tui", false, "Browse the RPCs in a terminal UI instead of writing them to stdout.")
+		include, exclude repeatedFlag
 	)
+	flag.Var(&include, "include", "Only dump RPCs matching this filter expression (e.g. 'service=foo.* && status!=OK'). Can be repeated: RPCs matching any expression are dumped.")
+	flag.Var(&exclude, "exclude", "Don't dump RPCs matching this filter expression. Can be repeated.")
 
 	grpc_proxy.RegisterDefaultFlags()
+	redact.RegisterFlags()
 	flag.Parse()
-	err := dump.Run(os.Stdout, *protoRoots, *protoDescriptors, grpc_proxy.DefaultFlags())
+
+	redactor, err := redact.FromFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	dumpOptions := []dump.Option{
+		dump.WithFormat(dump.Format(*outputFormat)),
+		dump.WithRedactor(redactor),
+	}
+	var index *os.File
+	if *indexFile != "" {
+		index, err = os.Create(*indexFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		dumpOptions = append(dumpOptions, dump.WithIndex(index))
+	}
+	for _, expression := range include {
+		dumpOptions = append(dumpOptions, dump.WithInclude(expression))
+	}
+	for _, expression := range exclude {
+		dumpOptions = append(dumpOptions, dump.WithExclude(expression))
+	}
+	if *showTUI {
+		if *outputFormat != string(dump.FormatJSON) {
+			fmt.Fprintln(os.Stderr, "--output_format can't be used with --tui")
+			os.Exit(1)
+		}
+		err = runTUI(*protoRoots, *protoDescriptors, dumpOptions)
+	} else {
+		err = dump.RunWithOptions(os.Stdout, *protoRoots, *protoDescriptors, dumpOptions, grpc_proxy.DefaultFlags(), grpc_proxy.StopOnInterrupt())
+	}
+	if index != nil {
+		if syncErr := index.Sync(); err == nil {
+			err = syncErr
+		}
+		if closeErr := index.Close(); err == nil {
+			err = closeErr
+		}
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		flag.Usage()
 		os.Exit(1)
 	}
 }
+
+// runTUI runs the proxy while showing the dumped RPCs in a terminal UI
+func runTUI(protoRoots, protoDescriptors string, dumpOptions []dump.Option) error {
+	// check that the UI can be shown before starting the proxy
+	if err := tui.CheckTerminal(os.Stdin); err != nil {
+		return err
+	}
+	ui := tui.New(dump.ParseFilter)
+
+	// the proxy is stopped when the user quits (after the terminal has been restored)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	proxyErr := make(chan error, 1)
+	go func() {
+		// logs are shown in the UI's status bar instead of garbling it
+		dumpOptions = append(dumpOptions, dump.WithDumpedCallback(ui.Add), dump.WithLogOutput(ui))
+		proxyErr <- dump.RunWithOptions(ioutil.Discard, protoRoots, protoDescriptors, dumpOptions,
+			grpc_proxy.DefaultFlags(),
+			grpc_proxy.StopOnInterrupt(),
+			grpc_proxy.StopWhenDone(ctx),
+			grpc_proxy.WithLogOutput(ui),
+		)
+		ui.Close()
+	}()
+
+	uiErr := ui.Run(os.Stdin, os.Stdout)
+	stop()
+	if err := <-proxyErr; err != nil {
+		return err
+	}
+	return uiErr
+}
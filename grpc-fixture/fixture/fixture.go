@@ -1,13 +1,152 @@
 package fixture
 
 import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
-	"strings"
+	"github.com/bradleyjkemp/grpc-tools/internal/redact"
+	"github.com/sirupsen/logrus"
 )
 
-// Run is exported for testing
+type options struct {
+	redactor      *redact.Redactor
+	matchMode     MatchMode
+	ignoredFields []string
+	matchKey      matchKey
+	timing        timing
+	playback      PlaybackMode
+	recordMisses  bool
+	watchInterval time.Duration
+	reportPath    string
+}
+
+type Option func(*options)
+
+// WithRedactor redacts recorded and received messages before they are matched
+// so that dumps recorded with redaction enabled still match real requests.
+func WithRedactor(redactor *redact.Redactor) Option {
+	return func(o *options) {
+		o.redactor = redactor
+	}
+}
+
+// WithMatchMode sets how received requests are matched against recorded requests (MatchExact by default)
+func WithMatchMode(mode MatchMode) Option {
+	return func(o *options) {
+		o.matchMode = mode
+	}
+}
+
+// WithIgnoredFields sets fields which are ignored when matching requests using MatchFields.
+// Patterns are matched against the fully qualified field name (e.g. foo.v1.Request.request_id or *.request_id)
+// and the path of the field in the request (e.g. header.request_id).
+func WithIgnoredFields(patterns []string) Option {
+	return func(o *options) {
+		o.ignoredFields = append(o.ignoredFields, patterns...)
+	}
+}
+
+// WithMatchAuthority only matches requests to RPCs recorded with the same :authority
+// so that responses from different hosts exposing the same service are kept apart.
+func WithMatchAuthority() Option {
+	return func(o *options) {
+		o.matchKey.authority = true
+	}
+}
+
+// WithMatchMetadata only matches requests to RPCs recorded with the same values of these metadata keys (e.g. a tenant header).
+func WithMatchMetadata(keys []string) Option {
+	return func(o *options) {
+		for _, key := range keys {
+			o.matchKey.metadataKeys = append(o.matchKey.metadataKeys, strings.ToLower(key))
+		}
+	}
+}
+
+// WithTiming sets when server messages are sent (TimingInstant by default).
+// With TimingRecorded, the recorded delays are divided by speed and randomly changed by up to +/- jitter.
+func WithTiming(mode TimingMode, speed float64, jitter time.Duration) Option {
+	return func(o *options) {
+		o.timing = timing{
+			mode:   mode,
+			speed:  speed,
+			jitter: jitter,
+		}
+	}
+}
+
+// WithPlayback sets how the responses recorded for identical requests are replayed (PlaybackFirst by default).
+// Sequential playback is stateful: each request consumes the next recorded response, e.g. to replay polling or pagination.
+func WithPlayback(mode PlaybackMode) Option {
+	return func(o *options) {
+		o.playback = mode
+	}
+}
+
+// WithRecordMisses forwards requests without saved responses to the real server (like grpc-dump)
+// and appends the RPCs to the dump so that they're replayed next time.
+// The first dump path must be a file.
+func WithRecordMisses() Option {
+	return func(o *options) {
+		o.recordMisses = true
+	}
+}
+
+// WithWatch polls the dumps for changes every interval and reloads the fixture when they change.
+// If the changed dumps can't be loaded, the previous fixture is kept.
+func WithWatch(interval time.Duration) Option {
+	return func(o *options) {
+		o.watchInterval = interval
+	}
+}
+
+// WithReport writes a report of the requests that didn't match the fixture and the
+// recorded messages that were never used to a JSON file when the fixture is stopped
+// (or, except on Windows, when it receives SIGUSR1).
+func WithReport(path string) Option {
+	return func(o *options) {
+		o.reportPath = path
+	}
+}
+
+// Run is exported for testing.
+// dumpPath is a comma separated list of dump files or directories of dump files.
 func Run(protoRoots, protoDescriptors, dumpPath string, proxyConfig ...grpc_proxy.Configurator) error {
+	return RunWithOptions(protoRoots, protoDescriptors, dumpPath, nil, proxyConfig...)
+}
+
+// RunWithOptions is like Run but serves the fixture using the fixture options (e.g. a different match mode)
+func RunWithOptions(protoRoots, protoDescriptors, dumpPath string, fixtureOptions []Option, proxyConfig ...grpc_proxy.Configurator) error {
+	o := &options{
+		matchMode: MatchExact,
+		playback:  PlaybackFirst,
+		timing: timing{
+			mode:  TimingInstant,
+			speed: 1,
+		},
+	}
+	for _, option := range fixtureOptions {
+		option(o)
+	}
+	if o.matchMode != MatchExact && o.matchMode != MatchFields {
+		return fmt.Errorf("unknown match mode %q", o.matchMode)
+	}
+	if err := o.playback.validate(); err != nil {
+		return err
+	}
+	if err := o.timing.validate(); err != nil {
+		return err
+	}
+	for _, pattern := range o.ignoredFields {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ignored field pattern %q: %v", pattern, err)
+		}
+	}
+
 	var resolvers []proto_decoder.MessageResolver
 	if protoRoots != "" {
 		r, err := proto_decoder.NewFileResolver(strings.Split(protoRoots, ",")...)
@@ -25,17 +164,71 @@ func Run(protoRoots, protoDescriptors, dumpPath string, proxyConfig ...grpc_prox
 	}
 	encoder := proto_decoder.NewEncoder(resolvers...)
 
-	interceptor, err := loadFixture(dumpPath, encoder)
+	interceptor := &fixtureInterceptor{
+		redactor:      o.redactor,
+		decoder:       proto_decoder.NewDecoder(logrus.New(), resolvers...),
+		encoder:       encoder,
+		matchMode:     o.matchMode,
+		ignoredFields: o.ignoredFields,
+		matchKey:      o.matchKey,
+		timing:        o.timing,
+		playback:      o.playback,
+	}
+	dumpPaths := strings.Split(dumpPath, ",")
+	interceptor.load = func() (fixture, error) {
+		return loadFixture(dumpPaths, encoder, o.matchKey, interceptor.redact)
+	}
+	fixture, err := interceptor.load()
 	if err != nil {
 		return err
 	}
+	interceptor.fixture = fixture
+
+	if o.recordMisses {
+		// new RPCs are recorded in the first dump
+		record, closeRecord, err := recordInterceptor(dumpPaths[0], interceptor)
+		if err != nil {
+			return err
+		}
+		interceptor.record = record
+		defer func() {
+			if err := closeRecord(); err != nil {
+				logrus.WithError(err).Error("Failed to close the dump being recorded to")
+			}
+		}()
+	}
+	if o.reportPath != "" {
+		interceptor.reporter = &reporter{
+			path:    o.reportPath,
+			decoder: interceptor.decoder,
+		}
+		stopReport := notifyReport(func() {
+			if err := interceptor.writeReport(); err != nil {
+				logrus.WithError(err).Error("Failed to write report")
+			}
+		})
+		defer stopReport()
+	}
+	if o.watchInterval > 0 {
+		// stop watching once the proxy has stopped
+		stopWatching := make(chan struct{})
+		defer close(stopWatching)
+		go watchDumps(dumpPaths, o.watchInterval, interceptor.reload, stopWatching)
+	}
 
 	proxy, err := grpc_proxy.New(
-		append(proxyConfig, grpc_proxy.WithInterceptor(interceptor.intercept))...,
+		append(proxyConfig,
+			grpc_proxy.WithInterceptor(interceptor.intercept),
+			grpc_proxy.WithMessageDecoder(interceptor.decoder),
+		)...,
 	)
 	if err != nil {
 		return err
 	}
 
-	return proxy.Start()
+	err = proxy.Start()
+	if reportErr := interceptor.writeReport(); err == nil {
+		err = reportErr
+	}
+	return err
 }
@@ -1,21 +1,84 @@
 package fixture
 
 import (
+	"io"
+	"sync"
+	"time"
+
 	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/redact"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-// fixtureInterceptor implements a gRPC.StreamingServerInterceptor that replays saved responses
-func (f fixture) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, _ grpc.StreamHandler) error {
-	messageTreeNode := f[info.FullMethod]
+type fixtureInterceptor struct {
+	// guards fixture which is replaced when it's reloaded
+	sync.RWMutex
+	fixture fixture
+	load    func() (fixture, error)
+	// forwards and dumps RPCs without saved responses (nil unless recording misses)
+	record grpc.StreamServerInterceptor
+	// nil unless reporting
+	reporter *reporter
+
+	decoder       proto_decoder.MessageDecoder
+	encoder       proto_decoder.MessageEncoder
+	redactor      *redact.Redactor
+	matchMode     MatchMode
+	ignoredFields []string
+	matchKey      matchKey
+	timing        timing
+	playback      PlaybackMode
+	played        playbackState
+}
+
+// redact applies the same redaction to a message as grpc-dump would have done when recording it
+func (f *fixtureInterceptor) redact(fullMethod string, origin internal.MessageOrigin, raw []byte) []byte {
+	if f.redactor == nil {
+		return raw
+	}
+	message := &internal.Message{
+		MessageOrigin: origin,
+		RawMessage:    raw,
+	}
+	decoded, err := f.decoder.Decode(fullMethod, message)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to decode message for redaction")
+	}
+	if err := f.redactor.DumpedMessage(message, decoded); err != nil {
+		logrus.WithError(err).Warn("Failed to redact message")
+	}
+	return message.RawMessage
+}
+
+// intercept implements a gRPC.StreamingServerInterceptor that replays saved responses
+func (f *fixtureInterceptor) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	key := f.matchKey.key(info.FullMethod, f.redactor.Metadata(md))
+	f.RLock()
+	messageTreeNode := f.fixture[key]
+	f.RUnlock()
 
 	if messageTreeNode == nil {
-		return status.Error(codes.Unavailable, "no saved responses found for method "+info.FullMethod)
+		return f.miss(srv, ss, info, handler, md, nil, status.Error(codes.Unavailable, "no saved responses found for method "+key))
 	}
+	f.reporter.hit(messageTreeNode)
+
+	// client messages received so far (before redaction) in case they need forwarding to the server
+	var received [][]byte
 
+	sentHeaders := false
+	previous := time.Now()
 	for {
+		if len(messageTreeNode.nextMessages) == 0 {
+			// end of the exchange
+			return finish(ss, messageTreeNode.end, sentHeaders)
+		}
+
 		// possibility that server sends the first method
 		serverFirst := len(messageTreeNode.nextMessages) > 0
 		for _, message := range messageTreeNode.nextMessages {
@@ -23,45 +86,112 @@ func (f fixture) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.Str
 		}
 
 		if serverFirst {
-			for _, message := range messageTreeNode.nextMessages {
-				if message.origin == internal.ServerMessage {
-					err := ss.SendMsg([]byte(message.raw))
-					if err != nil {
-						return err
-					}
-
-					// recurse deeper into the tree
-					messageTreeNode = message
-
-					// found the first server message so break
-					break
+			message := f.played.nextServerMessage(messageTreeNode, f.playback)
+			if err := f.timing.wait(ss.Context(), previous, message.delay); err != nil {
+				return err
+			}
+			if !sentHeaders && message.headers != nil {
+				if err := ss.SendHeader(message.headers); err != nil {
+					return err
+				}
+			}
+			response := []byte(message.raw)
+			if message.template != nil {
+				var err error
+				response, err = f.renderResponse(info.FullMethod, message.template, received, md)
+				if err != nil {
+					return status.Error(codes.Internal, err.Error())
 				}
 			}
+			sentHeaders = true
+			err := ss.SendMsg(response)
+			if err != nil {
+				return err
+			}
+			previous = time.Now()
+			f.reporter.hit(message)
+
+			// recurse deeper into the tree
+			messageTreeNode = message
 		} else {
 			// wait for a client message and then proceed based on its contents
 			var receivedMessage []byte
 			err := ss.RecvMsg(&receivedMessage)
+			if err == io.EOF && messageTreeNode.end != nil {
+				// the client finished at the same point as a recorded RPC
+				return finish(ss, messageTreeNode.end, sentHeaders)
+			}
 			if err != nil {
 				return err
 			}
-			var found bool
-			for _, message := range messageTreeNode.nextMessages {
-				if message.origin == internal.ClientMessage && message.raw == string(receivedMessage) {
-					// found the matching message so recurse deeper into the tree
-					messageTreeNode = message
-					found = true
-					break
+			previous = time.Now()
+			received = append(received, receivedMessage)
+			receivedMessage = f.redact(info.FullMethod, internal.ClientMessage, receivedMessage)
+			match := f.matchClientMessage(info.FullMethod, messageTreeNode.nextMessages, receivedMessage)
+			if match == nil {
+				err := status.Errorf(codes.Unavailable, "no matching saved responses for method %s and message", info.FullMethod)
+				if sentHeaders {
+					// the server can't be asked to continue from part way through a replayed RPC
+					f.reportUnmatched(info.FullMethod, md, received, err, false)
+					return err
 				}
+				return f.miss(srv, ss, info, handler, md, received, err)
 			}
-
-			if !found {
-				return status.Errorf(codes.Unavailable, "no matching saved responses for method %s and message", info.FullMethod)
-			}
+			// found the matching message so recurse deeper into the tree
+			f.reporter.hit(match)
+			messageTreeNode = match
 		}
+	}
+}
 
-		if len(messageTreeNode.nextMessages) == 0 {
-			// end of the exchange
-			return nil
+// miss handles a request without saved responses: returning err unless the RPC can be recorded
+func (f *fixtureInterceptor) miss(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler, md metadata.MD, received [][]byte, err error) error {
+	if f.record == nil {
+		f.reportUnmatched(info.FullMethod, md, received, err, false)
+		return err
+	}
+	f.reportUnmatched(info.FullMethod, md, received, err, true)
+	logrus.WithField("method", info.FullMethod).Info("No saved responses, forwarding request to the server")
+	return f.record(srv, &replayedServerStream{ServerStream: ss, received: received}, info, handler)
+}
+
+// reportUnmatched reports a request which didn't match with the same redaction as grpc-dump would apply
+func (f *fixtureInterceptor) reportUnmatched(fullMethod string, md metadata.MD, received [][]byte, err error, recorded bool) {
+	if f.reporter == nil {
+		return
+	}
+	redacted := make([][]byte, len(received))
+	for i, raw := range received {
+		redacted[i] = f.redact(fullMethod, internal.ClientMessage, raw)
+	}
+	f.reporter.unmatchedRequest(fullMethod, f.redactor.Metadata(md), redacted, err, recorded)
+}
+
+// reload replaces the fixture with the current contents of the dump
+func (f *fixtureInterceptor) reload() {
+	fixture, err := f.load()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to reload fixture, keeping the previous one")
+		return
+	}
+	f.Lock()
+	f.fixture = fixture
+	f.Unlock()
+	logrus.Info("Reloaded fixture")
+}
+
+// finish ends the RPC with the recorded status and response metadata
+func finish(ss grpc.ServerStream, end *rpcEnd, sentHeaders bool) error {
+	if end == nil {
+		return nil
+	}
+	if !sentHeaders && len(end.headers) > 0 {
+		// headers have to be sent explicitly as they're dropped from
+		// trailers-only responses when served over HTTP handlers
+		if err := ss.SendHeader(end.headers); err != nil {
+			return err
 		}
 	}
+	ss.SetTrailer(end.trailers)
+	return end.status.Err()
 }
@@ -0,0 +1,75 @@
+package fixture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplayStatusAndMetadata(t *testing.T) {
+	dump, err := ioutil.TempFile("", "fixture")
+	require.NoError(t, err)
+	defer os.Remove(dump.Name())
+	encoder := json.NewEncoder(dump)
+	require.NoError(t, encoder.Encode(internal.RPC{
+		Service: "foo.Service",
+		Method:  "Get",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, RawMessage: []byte("\x08\x01")},
+			{MessageOrigin: internal.ServerMessage, RawMessage: []byte("\x08\x02")},
+		},
+		Status:               &internal.Status{Code: codes.FailedPrecondition.String(), Message: "partial result"},
+		MetadataRespHeaders:  metadata.Pairs("content-type", "application/grpc", "x-request-id", "1"),
+		MetadataRespTrailers: metadata.Pairs("grpc-status", "9", "x-retry-after", "5"),
+	}))
+	// a trailers-only response (no server messages)
+	require.NoError(t, encoder.Encode(internal.RPC{
+		Service: "foo.Service",
+		Method:  "Get",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, RawMessage: []byte("\x08\x03")},
+		},
+		Status:               &internal.Status{Code: codes.NotFound.String(), Message: "no such thing"},
+		MetadataRespHeaders:  metadata.Pairs("x-request-id", "2"),
+		MetadataRespTrailers: metadata.Pairs("x-reason", "deleted"),
+	}))
+	require.NoError(t, dump.Close())
+
+	f := &fixtureInterceptor{decoder: proto_decoder.NewDecoder(logrus.New())}
+	f.fixture, err = loadFixture([]string{dump.Name()}, proto_decoder.NewEncoder(), matchKey{}, f.redact)
+	require.NoError(t, err)
+	info := &grpc.StreamServerInfo{FullMethod: "/foo.Service/Get"}
+	notForwarded := func(interface{}, grpc.ServerStream) error {
+		return errors.New("the request should have been replayed")
+	}
+
+	ss := newTestServerStream("\x08\x01")
+	err = f.intercept(nil, ss, info, notForwarded)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Equal(t, "partial result", status.Convert(err).Message())
+	require.Equal(t, [][]byte{[]byte("\x08\x02")}, ss.sent)
+	// headers set by the gRPC server itself aren't replayed
+	require.Equal(t, metadata.Pairs("x-request-id", "1"), ss.header)
+	require.Equal(t, metadata.Pairs("x-retry-after", "5"), ss.trailer)
+
+	ss = newTestServerStream("\x08\x03")
+	err = f.intercept(nil, ss, info, notForwarded)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Equal(t, "no such thing", status.Convert(err).Message())
+	require.Empty(t, ss.sent)
+	// the headers must be sent explicitly as there's no server message to send them with
+	require.True(t, ss.sentHeader)
+	require.Equal(t, metadata.Pairs("x-request-id", "2"), ss.header)
+	require.Equal(t, metadata.Pairs("x-reason", "deleted"), ss.trailer)
+}
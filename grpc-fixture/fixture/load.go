@@ -1,50 +1,142 @@
 package fixture
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpfile"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
 	"io"
 	"os"
+	"strings"
+	"time"
 )
 
-// map of service name to message tree
+// map of method name (and any other parts of the match key) to message tree
 type fixture map[string]*messageTree
 
 type messageTree struct {
 	origin       internal.MessageOrigin
 	raw          string
 	nextMessages []*messageTree
+
+	// identifies this node across reloads of the fixture (see childID)
+	id nodeID
+
+	// the server messages that followed this message in each recorded RPC (in order)
+	sequence []*messageTree
+
+	// set if this is a server message which is rendered using the request
+	template *responseTemplate
+
+	// time since the previous message of the recorded RPC
+	delay time.Duration
+
+	// response headers to send before this message if it's the first server message of an RPC
+	headers metadata.MD
+	// set if a recorded RPC ended after this message
+	end *rpcEnd
 }
 
-// load fixture creates a Trie-like structure of messages
-func loadFixture(dumpPath string, encoder proto_decoder.MessageEncoder) (fixture, error) {
-	dumpFile, err := os.Open(dumpPath)
+// nodeID is a hash of the match key and the messages leading to a node
+type nodeID [sha256.Size]byte
+
+// childID returns the ID of the node for the next message after this one
+func (m *messageTree) childID(origin internal.MessageOrigin, raw []byte) nodeID {
+	h := sha256.New()
+	h.Write(m.id[:])
+	h.Write([]byte(origin))
+	h.Write(raw)
+	var id nodeID
+	h.Sum(id[:0])
+	return id
+}
+
+type rpcEnd struct {
+	status *internal.Status
+	// sent if the RPC ended before any server messages
+	headers  metadata.MD
+	trailers metadata.MD
+}
+
+// responseMetadata removes the headers which are set by the gRPC server itself
+func responseMetadata(md metadata.MD) metadata.MD {
+	filtered := metadata.MD{}
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || key == "content-type" || key == "trailer" {
+			continue
+		}
+		filtered[key] = values
+	}
+	return filtered
+}
+
+// load fixture creates a Trie-like structure of the messages in all of the dumps
+// (dump paths can be files or directories of dump files).
+// redact is applied to client messages so that they can be compared to redacted received messages.
+func loadFixture(dumpPaths []string, encoder proto_decoder.MessageEncoder, key matchKey, redact func(string, internal.MessageOrigin, []byte) []byte) (fixture, error) {
+	files, err := dumpFiles(dumpPaths)
 	if err != nil {
 		return nil, err
 	}
+	fixture := fixture{}
+	for _, file := range files {
+		if err := fixture.addDump(file, encoder, key, redact); err != nil {
+			return nil, errors.Wrap(err, file)
+		}
+	}
+	return fixture, nil
+}
 
-	dumpDecoder := json.NewDecoder(dumpFile)
-	fixture := map[string]*messageTree{}
+func (fixture fixture) addDump(dumpPath string, encoder proto_decoder.MessageEncoder, key matchKey, redact func(string, internal.MessageOrigin, []byte) []byte) error {
+	dumpFile, err := os.Open(dumpPath)
+	if err != nil {
+		return err
+	}
+	defer dumpFile.Close()
 
+	dumpReader := dumpfile.NewReader(dumpFile)
 	for {
-		rpc := internal.RPC{}
-		err := dumpDecoder.Decode(&rpc)
+		rpc, err := dumpReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		if fixture[rpc.StreamName()] == nil {
-			fixture[rpc.StreamName()] = &messageTree{}
+		rpcKey := key.key(rpc.StreamName(), rpc.Metadata)
+		if fixture[rpcKey] == nil {
+			fixture[rpcKey] = &messageTree{id: sha256.Sum256([]byte(rpcKey))}
 		}
-		messageTreeNode := fixture[rpc.StreamName()]
+		messageTreeNode := fixture[rpcKey]
+		headers := responseMetadata(rpc.MetadataRespHeaders)
+		sentHeaders := false
+		var previous time.Time
 		for _, msg := range rpc.Messages {
-			msgBytes, err := encoder.Encode(rpc.StreamName(), msg)
+			var msgBytes []byte
+			var responseTemplate *responseTemplate
+			if msg.Template {
+				if msg.MessageOrigin != internal.ServerMessage {
+					return fmt.Errorf("%s: only server messages can be templates", rpc.StreamName())
+				}
+				responseTemplate, err = newResponseTemplate(msg.Message)
+				if err != nil {
+					return errors.Wrap(err, rpc.StreamName())
+				}
+				// the template itself identifies the message in the tree
+				msgBytes, err = json.Marshal(msg.Message)
+			} else {
+				msgBytes, err = encoder.Encode(rpc.StreamName(), msg)
+			}
 			if err != nil {
-				return nil, err
+				return err
+			}
+			if msg.MessageOrigin == internal.ClientMessage {
+				msgBytes = redact(rpc.StreamName(), msg.MessageOrigin, msgBytes)
 			}
 			var foundExisting *messageTree
 			for _, nextMessage := range messageTreeNode.nextMessages {
@@ -58,13 +150,42 @@ func loadFixture(dumpPath string, encoder proto_decoder.MessageEncoder) (fixture
 					origin:       msg.MessageOrigin,
 					raw:          string(msgBytes),
 					nextMessages: nil,
+					id:           messageTreeNode.childID(msg.MessageOrigin, msgBytes),
+					template:     responseTemplate,
+				}
+				if !previous.IsZero() && msg.Timestamp.After(previous) {
+					foundExisting.delay = msg.Timestamp.Sub(previous)
 				}
 				messageTreeNode.nextMessages = append(messageTreeNode.nextMessages, foundExisting)
 			}
 
+			if msg.MessageOrigin == internal.ServerMessage {
+				messageTreeNode.sequence = append(messageTreeNode.sequence, foundExisting)
+			}
+			if msg.MessageOrigin == internal.ServerMessage && !sentHeaders {
+				if foundExisting.headers == nil {
+					foundExisting.headers = headers
+				}
+				sentHeaders = true
+			}
+
 			messageTreeNode = foundExisting
+			if !msg.Timestamp.IsZero() {
+				previous = msg.Timestamp
+			}
+		}
+
+		if messageTreeNode.end == nil {
+			end := &rpcEnd{
+				status:   rpc.Status,
+				trailers: responseMetadata(rpc.MetadataRespTrailers),
+			}
+			if !sentHeaders {
+				end.headers = headers
+			}
+			messageTreeNode.end = end
 		}
 	}
 
-	return fixture, nil
+	return nil
 }
@@ -0,0 +1,208 @@
+package fixture
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+type MatchMode string
+
+const (
+	// MatchExact only replays responses to requests which are byte for byte identical to the recorded request
+	MatchExact MatchMode = "exact"
+	// MatchFields decodes requests and compares them field by field (skipping ignored fields).
+	// If no recorded request matches, the closest one is used instead.
+	MatchFields MatchMode = "fields"
+)
+
+// matchKey selects which parts of an RPC (other than its method) must be the same for a request to match a recorded RPC
+type matchKey struct {
+	authority    bool
+	metadataKeys []string
+}
+
+// key returns the key of the fixture tree that an RPC is matched against
+func (k matchKey) key(fullMethod string, md metadata.MD) string {
+	key := fullMethod
+	if k.authority {
+		key += " :authority=" + strings.Join(md.Get(":authority"), ",")
+	}
+	for _, metadataKey := range k.metadataKeys {
+		key += fmt.Sprintf(" %s=%s", metadataKey, strings.Join(md.Get(metadataKey), ","))
+	}
+	return key
+}
+
+// matchClientMessage finds the recorded client message that received matches
+func (f *fixtureInterceptor) matchClientMessage(fullMethod string, candidates []*messageTree, received []byte) *messageTree {
+	for _, candidate := range candidates {
+		if candidate.origin == internal.ClientMessage && candidate.raw == string(received) {
+			return candidate
+		}
+	}
+	if f.matchMode != MatchFields {
+		return nil
+	}
+
+	decodedReceived, err := f.decoder.Decode(fullMethod, &internal.Message{
+		MessageOrigin: internal.ClientMessage,
+		RawMessage:    received,
+	})
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to decode message for matching")
+		return nil
+	}
+
+	var closest *messageTree
+	var closestDiff []string
+	for _, candidate := range candidates {
+		if candidate.origin != internal.ClientMessage {
+			continue
+		}
+		decodedCandidate, err := f.decoder.Decode(fullMethod, &internal.Message{
+			MessageOrigin: internal.ClientMessage,
+			RawMessage:    []byte(candidate.raw),
+		})
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to decode saved message for matching")
+			continue
+		}
+		diff := diffMessages(decodedCandidate, decodedReceived, f.ignoredFields, "")
+		if len(diff) == 0 {
+			return candidate
+		}
+		if closest == nil || len(diff) < len(closestDiff) {
+			closest = candidate
+			closestDiff = diff
+		}
+	}
+
+	if closest != nil {
+		logrus.WithField("method", fullMethod).
+			WithField("differing_fields", strings.Join(closestDiff, ",")).
+			Warn("No saved request matches, replaying responses to the closest saved request")
+	}
+	return closest
+}
+
+// diffMessages compares two messages field by field and returns the paths of the fields that differ.
+// Fields are matched by number as the descriptors of messages decoded without the proto
+// definitions only contain the fields present in that particular message.
+// Fields whose fully qualified name or path match one of the ignored patterns are skipped.
+func diffMessages(a, b *dynamic.Message, ignored []string, prefix string) []string {
+	fields := map[int32]*desc.FieldDescriptor{}
+	for _, field := range a.GetMessageDescriptor().GetFields() {
+		fields[field.GetNumber()] = field
+	}
+	for _, field := range b.GetMessageDescriptor().GetFields() {
+		if fields[field.GetNumber()] == nil {
+			fields[field.GetNumber()] = field
+		}
+	}
+	numbers := make([]int, 0, len(fields))
+	for number := range fields {
+		numbers = append(numbers, int(number))
+	}
+	sort.Ints(numbers)
+
+	var diff []string
+	for _, number := range numbers {
+		field := fields[int32(number)]
+		fieldPath := prefix + field.GetName()
+		if ignoredField(ignored, field, fieldPath) {
+			continue
+		}
+
+		aValue, aPresent := fieldValue(a, field.GetNumber())
+		bValue, bPresent := fieldValue(b, field.GetNumber())
+		switch {
+		case !aPresent && !bPresent:
+			continue
+		case aPresent != bPresent:
+			diff = append(diff, fieldPath)
+		case field.GetMessageType() != nil && !field.IsRepeated():
+			diff = append(diff, diffValues(aValue, bValue, ignored, fieldPath)...)
+		case field.IsMap():
+			aMap, bMap := aValue.(map[interface{}]interface{}), bValue.(map[interface{}]interface{})
+			keys := map[string]interface{}{}
+			for key := range aMap {
+				keys[fmt.Sprint(key)] = key
+			}
+			for key := range bMap {
+				keys[fmt.Sprint(key)] = key
+			}
+			for name, key := range keys {
+				diff = append(diff, diffValues(aMap[key], bMap[key], ignored, fmt.Sprintf("%s[%s]", fieldPath, name))...)
+			}
+		case field.IsRepeated():
+			aList, bList := aValue.([]interface{}), bValue.([]interface{})
+			if len(aList) != len(bList) {
+				diff = append(diff, fieldPath)
+				continue
+			}
+			for i := range aList {
+				diff = append(diff, diffValues(aList[i], bList[i], ignored, fmt.Sprintf("%s[%d]", fieldPath, i))...)
+			}
+		default:
+			diff = append(diff, diffValues(aValue, bValue, ignored, fieldPath)...)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func diffValues(a, b interface{}, ignored []string, fieldPath string) []string {
+	aMessage, aOk := a.(*dynamic.Message)
+	bMessage, bOk := b.(*dynamic.Message)
+	if aOk && bOk {
+		return diffMessages(aMessage, bMessage, ignored, fieldPath+".")
+	}
+
+	var equal bool
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		equal = ok && bytes.Equal(a, b)
+	case proto.Message:
+		b, ok := b.(proto.Message)
+		equal = ok && proto.Equal(a, b)
+	default:
+		equal = reflect.DeepEqual(a, b)
+	}
+	if equal {
+		return nil
+	}
+	return []string{fieldPath}
+}
+
+// fieldValue returns the value of a field and whether it's set
+func fieldValue(message *dynamic.Message, number int32) (interface{}, bool) {
+	field := message.FindFieldDescriptor(number)
+	if field == nil || !message.HasField(field) {
+		return nil, false
+	}
+	return message.GetField(field), true
+}
+
+func ignoredField(patterns []string, field *desc.FieldDescriptor, fieldPath string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, field.GetFullyQualifiedName()); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, fieldPath); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package fixture
+
+import (
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDiffMessages(t *testing.T) {
+	header := builder.NewMessage("Header").
+		AddField(builder.NewField("request_id", builder.FieldTypeString())).
+		AddField(builder.NewField("timestamp", builder.FieldTypeInt64()))
+	request, err := builder.NewMessage("Request").
+		AddField(builder.NewField("header", builder.FieldTypeMessage(header))).
+		AddField(builder.NewField("name", builder.FieldTypeString())).
+		AddField(builder.NewField("tags", builder.FieldTypeString()).SetRepeated()).
+		Build()
+	require.NoError(t, err)
+
+	newRequest := func(requestID string, timestamp int64, name string, tags ...interface{}) *dynamic.Message {
+		h := dynamic.NewMessage(request.FindFieldByName("header").GetMessageType())
+		h.SetFieldByName("request_id", requestID)
+		h.SetFieldByName("timestamp", timestamp)
+		m := dynamic.NewMessage(request)
+		m.SetFieldByName("header", h)
+		m.SetFieldByName("name", name)
+		if len(tags) > 0 {
+			m.SetFieldByName("tags", tags)
+		}
+		return m
+	}
+
+	recorded := newRequest("a", 1, "foo", "x")
+	require.Empty(t, diffMessages(recorded, newRequest("a", 1, "foo", "x"), nil, ""))
+	require.Equal(t, []string{"header.request_id", "header.timestamp"}, diffMessages(recorded, newRequest("b", 2, "foo", "x"), nil, ""))
+	require.Equal(t, []string{"name", "tags"}, diffMessages(recorded, newRequest("a", 1, "bar"), nil, ""))
+	require.Equal(t, []string{"tags[0]"}, diffMessages(recorded, newRequest("a", 1, "foo", "y"), nil, ""))
+
+	// ignored fields are matched by fully qualified name or path
+	require.Empty(t, diffMessages(recorded, newRequest("b", 2, "foo", "x"), []string{"*.request_id", "header.timestamp"}, ""))
+	require.Equal(t, []string{"header.timestamp"}, diffMessages(recorded, newRequest("b", 2, "foo", "x"), []string{"Header.request_id"}, ""))
+}
+
+func TestMatchKey(t *testing.T) {
+	md := metadata.Pairs(":authority", "api.example.com", "x-tenant", "a")
+	require.Equal(t, "/foo.Service/Get", matchKey{}.key("/foo.Service/Get", md))
+	require.Equal(t,
+		"/foo.Service/Get :authority=api.example.com x-tenant=a x-missing=",
+		matchKey{authority: true, metadataKeys: []string{"x-tenant", "x-missing"}}.key("/foo.Service/Get", md),
+	)
+}
+
+// requestDecoder decodes every message as the same type
+type requestDecoder struct {
+	descriptor *desc.MessageDescriptor
+}
+
+func (d requestDecoder) Decode(_ string, message *internal.Message) (*dynamic.Message, error) {
+	decoded := dynamic.NewMessage(d.descriptor)
+	return decoded, decoded.Unmarshal(message.RawMessage)
+}
+
+func TestMatchClientMessage(t *testing.T) {
+	request, err := builder.NewMessage("Request").
+		AddField(builder.NewField("request_id", builder.FieldTypeString())).
+		AddField(builder.NewField("name", builder.FieldTypeString())).
+		AddField(builder.NewField("page", builder.FieldTypeInt32())).
+		Build()
+	require.NoError(t, err)
+	encode := func(requestID, name string, page int32) []byte {
+		m := dynamic.NewMessage(request)
+		m.SetFieldByName("request_id", requestID)
+		m.SetFieldByName("name", name)
+		m.SetFieldByName("page", page)
+		raw, err := m.Marshal()
+		require.NoError(t, err)
+		return raw
+	}
+
+	first := &messageTree{origin: internal.ClientMessage, raw: string(encode("a", "foo", 1))}
+	second := &messageTree{origin: internal.ClientMessage, raw: string(encode("b", "bar", 2))}
+	candidates := []*messageTree{first, second}
+	f := &fixtureInterceptor{decoder: requestDecoder{request}}
+
+	// requests must be identical unless matching fields
+	require.Equal(t, second, f.matchClientMessage("/foo.Service/Get", candidates, encode("b", "bar", 2)))
+	require.Nil(t, f.matchClientMessage("/foo.Service/Get", candidates, encode("c", "bar", 2)))
+
+	f.matchMode = MatchFields
+	// the closest request is used if none match
+	require.Equal(t, second, f.matchClientMessage("/foo.Service/Get", candidates, encode("c", "bar", 2)))
+	require.Equal(t, first, f.matchClientMessage("/foo.Service/Get", candidates, encode("c", "foo", 1)))
+
+	// an exact match (ignoring fields) is preferred to the closest request
+	f.ignoredFields = []string{"request_id", "name"}
+	require.Equal(t, second, f.matchClientMessage("/foo.Service/Get", candidates, encode("c", "foo", 2)))
+}
@@ -0,0 +1,67 @@
+package fixture
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+)
+
+// PlaybackMode chooses between the responses recorded for identical requests
+type PlaybackMode string
+
+const (
+	// PlaybackFirst always replays the first recorded response
+	PlaybackFirst PlaybackMode = "first"
+	// PlaybackSequential replays the recorded responses in order and then keeps replaying the last one
+	PlaybackSequential PlaybackMode = "sequential"
+	// PlaybackLoop replays the recorded responses in order and then starts again from the first one
+	PlaybackLoop PlaybackMode = "loop"
+)
+
+func (p PlaybackMode) validate() error {
+	switch p {
+	case PlaybackFirst, PlaybackSequential, PlaybackLoop:
+		return nil
+	default:
+		return fmt.Errorf("unknown playback mode %q", p)
+	}
+}
+
+// playbackState is the number of times a server message has been chosen from the sequence following each
+// node of the fixture. It's keyed by node ID so that playback continues where it was when the fixture is reloaded.
+type playbackState struct {
+	sync.Mutex
+	played map[nodeID]int
+}
+
+// nextServerMessage chooses the server message to send after the message m
+func (p *playbackState) nextServerMessage(m *messageTree, mode PlaybackMode) *messageTree {
+	if mode == PlaybackFirst || len(m.sequence) == 0 {
+		for _, message := range m.nextMessages {
+			if message.origin == internal.ServerMessage {
+				return message
+			}
+		}
+		return nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.played == nil {
+		p.played = map[nodeID]int{}
+	}
+	played := p.played[m.id]
+	if played >= len(m.sequence) {
+		// the fixture has been reloaded with fewer recorded responses
+		played = len(m.sequence) - 1
+	}
+	next := m.sequence[played]
+	switch {
+	case played+1 < len(m.sequence):
+		p.played[m.id] = played + 1
+	case mode == PlaybackLoop:
+		p.played[m.id] = 0
+	}
+	return next
+}
@@ -0,0 +1,52 @@
+package fixture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequentialPlayback(t *testing.T) {
+	dump, err := ioutil.TempFile("", "fixture")
+	require.NoError(t, err)
+	defer os.Remove(dump.Name())
+	encoder := json.NewEncoder(dump)
+	for _, response := range []string{"1", "2", "1"} {
+		require.NoError(t, encoder.Encode(internal.RPC{
+			Service: "foo.Service",
+			Method:  "Poll",
+			Messages: []*internal.Message{
+				{MessageOrigin: internal.ClientMessage, RawMessage: []byte("poll")},
+				{MessageOrigin: internal.ServerMessage, RawMessage: []byte(response)},
+			},
+		}))
+	}
+	require.NoError(t, dump.Close())
+
+	noRedaction := func(_ string, _ internal.MessageOrigin, raw []byte) []byte { return raw }
+	playback := func(state *playbackState, mode PlaybackMode, n int) []string {
+		// the fixture is loaded each time as if it had been reloaded
+		f, err := loadFixture([]string{dump.Name()}, proto_decoder.NewEncoder(), matchKey{}, noRedaction)
+		require.NoError(t, err)
+		request := f["/foo.Service/Poll"].nextMessages[0]
+		var responses []string
+		for i := 0; i < n; i++ {
+			responses = append(responses, state.nextServerMessage(request, mode).raw)
+		}
+		return responses
+	}
+
+	require.Equal(t, []string{"1", "1", "1", "1"}, playback(&playbackState{}, PlaybackFirst, 4))
+	require.Equal(t, []string{"1", "2", "1", "1"}, playback(&playbackState{}, PlaybackSequential, 4))
+	require.Equal(t, []string{"1", "2", "1", "1", "2"}, playback(&playbackState{}, PlaybackLoop, 5))
+
+	// playback continues from the same place after a reload
+	state := &playbackState{}
+	require.Equal(t, []string{"1", "2"}, playback(state, PlaybackSequential, 2))
+	require.Equal(t, []string{"1", "1"}, playback(state, PlaybackSequential, 2))
+}
@@ -0,0 +1,90 @@
+package fixture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bradleyjkemp/grpc-tools/grpc-dump/dump"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpfile"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// recordInterceptor returns an interceptor which forwards RPCs to the server and appends them to the dump
+// (in the same format as the rest of the dump) and then reloads the fixture so that they can be replayed.
+// The returned function closes the dump once the proxy has stopped.
+func recordInterceptor(dumpPath string, f *fixtureInterceptor) (grpc.StreamServerInterceptor, func() error, error) {
+	existing, err := os.Open(dumpPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info, err := existing.Stat(); err != nil || info.IsDir() {
+		existing.Close()
+		if err == nil {
+			err = fmt.Errorf("%s: RPCs can only be recorded to a dump file, not a directory", dumpPath)
+		}
+		return nil, nil, err
+	}
+	format := dump.FormatJSON
+	existingReader := bufio.NewReader(existing)
+	if dumpfile.IsBinary(existingReader) {
+		format = dump.FormatBinary
+	} else if isEvents(existingReader) {
+		// events are only dumped once the RPC has been replayed so the fixture can't be reloaded
+		existing.Close()
+		return nil, nil, fmt.Errorf("%s: can't record to a dump in the %s format", dumpPath, dump.FormatEvents)
+	}
+	existing.Close()
+
+	output, err := os.OpenFile(dumpPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open dump for recording")
+	}
+	record, closeDump, err := dump.NewInterceptor(output, f.decoder,
+		dump.WithFormat(format),
+		dump.WithAppend(),
+		dump.WithRedactor(f.redactor),
+		dump.WithDumpedCallback(func(*internal.RPC) {
+			f.reload()
+		}),
+	)
+	if err != nil {
+		output.Close()
+		return nil, nil, err
+	}
+	return record, func() error {
+		err := closeDump()
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// isEvents returns whether a JSON dump is in the events format rather than an RPC per line
+func isEvents(r io.Reader) bool {
+	var probe struct {
+		StreamID string `json:"stream_id"`
+	}
+	return json.NewDecoder(r).Decode(&probe) == nil && probe.StreamID != ""
+}
+
+// replayedServerStream receives client messages that have already been received
+// from the underlying stream before receiving any more
+type replayedServerStream struct {
+	grpc.ServerStream
+	received [][]byte
+}
+
+func (ss *replayedServerStream) RecvMsg(m interface{}) error {
+	if len(ss.received) == 0 {
+		return ss.ServerStream.RecvMsg(m)
+	}
+	*m.(*[]byte) = ss.received[0]
+	ss.received = ss.received[1:]
+	return nil
+}
@@ -0,0 +1,154 @@
+package fixture
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpfile"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRecordInterceptor(t *testing.T) {
+	f := &fixtureInterceptor{decoder: proto_decoder.NewDecoder(logrus.New())}
+	writeDump := func(contents string) string {
+		dump, err := ioutil.TempFile("", "fixture")
+		require.NoError(t, err)
+		_, err = dump.WriteString(contents)
+		require.NoError(t, err)
+		require.NoError(t, dump.Close())
+		return dump.Name()
+	}
+
+	rpcs := writeDump(`{"service":"foo.Service","method":"Get","messages":[]}` + "\n")
+	defer os.Remove(rpcs)
+	record, closeRecord, err := recordInterceptor(rpcs, f)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	require.NoError(t, closeRecord())
+
+	events := writeDump(`{"stream_id":"1","event":"start","service":"foo.Service","method":"Get"}` + "\n")
+	defer os.Remove(events)
+	_, _, err = recordInterceptor(events, f)
+	require.Error(t, err, "RPCs can't be appended to an events dump")
+
+	_, _, err = recordInterceptor(os.TempDir(), f)
+	require.Error(t, err, "RPCs can't be appended to a directory")
+}
+
+func TestRecordMiss(t *testing.T) {
+	dump, err := ioutil.TempFile("", "fixture")
+	require.NoError(t, err)
+	defer os.Remove(dump.Name())
+	require.NoError(t, json.NewEncoder(dump).Encode(internal.RPC{
+		Service: "foo.Service",
+		Method:  "Get",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, RawMessage: []byte("\x08\x01")},
+			{MessageOrigin: internal.ServerMessage, RawMessage: []byte("\x08\x02")},
+		},
+	}))
+	require.NoError(t, dump.Close())
+
+	encoder := proto_decoder.NewEncoder()
+	f := &fixtureInterceptor{
+		decoder: proto_decoder.NewDecoder(logrus.New()),
+		encoder: encoder,
+	}
+	f.load = func() (fixture, error) {
+		return loadFixture([]string{dump.Name()}, encoder, matchKey{}, f.redact)
+	}
+	f.fixture, err = f.load()
+	require.NoError(t, err)
+	record, closeRecord, err := recordInterceptor(dump.Name(), f)
+	require.NoError(t, err)
+	f.record = record
+	info := &grpc.StreamServerInfo{FullMethod: "/foo.Service/Get"}
+
+	// the request doesn't match so (after it has been received) it's forwarded to the server
+	ss := newTestServerStream("\x08\x03")
+	err = f.intercept(nil, ss, info, func(_ interface{}, ss grpc.ServerStream) error {
+		var request []byte
+		require.NoError(t, ss.RecvMsg(&request))
+		require.Equal(t, []byte("\x08\x03"), request)
+		return ss.SendMsg([]byte("\x08\x04"))
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("\x08\x04")}, ss.sent)
+	require.NoError(t, closeRecord())
+
+	// the recorded RPC has been appended to the dump and reloaded so it's replayed
+	recorded, err := os.Open(dump.Name())
+	require.NoError(t, err)
+	defer recorded.Close()
+	rpcs, err := dumpfile.ReadAll(recorded)
+	require.NoError(t, err)
+	require.Len(t, rpcs, 2)
+	ss = newTestServerStream("\x08\x03")
+	err = f.intercept(nil, ss, info, func(interface{}, grpc.ServerStream) error {
+		return errors.New("the request should have been replayed")
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("\x08\x04")}, ss.sent)
+}
+
+// testServerStream receives the given client messages and records what's sent to the client
+type testServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	received [][]byte
+	sent     [][]byte
+	header   metadata.MD
+	trailer  metadata.MD
+	// whether the headers were sent explicitly
+	sentHeader bool
+}
+
+func newTestServerStream(received ...string) *testServerStream {
+	ss := &testServerStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.MD{})}
+	for _, message := range received {
+		ss.received = append(ss.received, []byte(message))
+	}
+	return ss
+}
+
+func (ss *testServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *testServerStream) RecvMsg(m interface{}) error {
+	if len(ss.received) == 0 {
+		return io.EOF
+	}
+	*m.(*[]byte) = ss.received[0]
+	ss.received = ss.received[1:]
+	return nil
+}
+
+func (ss *testServerStream) SendMsg(m interface{}) error {
+	ss.sent = append(ss.sent, m.([]byte))
+	return nil
+}
+
+func (ss *testServerStream) SetHeader(md metadata.MD) error {
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+func (ss *testServerStream) SendHeader(md metadata.MD) error {
+	ss.sentHeader = true
+	return ss.SetHeader(md)
+}
+
+func (ss *testServerStream) SetTrailer(md metadata.MD) {
+	ss.trailer = metadata.Join(ss.trailer, md)
+}
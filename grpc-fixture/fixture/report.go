@@ -0,0 +1,177 @@
+package fixture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// report lists the requests which didn't match the fixture and the recorded messages which were never used
+type report struct {
+	UnmatchedRequests []unmatchedRequest `json:"unmatched_requests"`
+	UnusedBranches    []unusedBranch     `json:"unused_branches"`
+}
+
+type unmatchedRequest struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Method    string      `json:"method"`
+	Metadata  metadata.MD `json:"metadata"`
+	// the client messages received before the request failed to match
+	Messages []reportMessage `json:"messages"`
+	Error    string          `json:"error"`
+	// whether the request was forwarded to the server and recorded (see WithRecordMisses)
+	Recorded bool `json:"recorded"`
+}
+
+// unusedBranch is the path through the fixture to a recorded message which was never used
+type unusedBranch struct {
+	// the method (and any other parts of the match key)
+	Key      string          `json:"key"`
+	Messages []reportMessage `json:"messages"`
+}
+
+type reportMessage struct {
+	MessageOrigin internal.MessageOrigin `json:"message_origin"`
+	RawMessage    []byte                 `json:"raw_message"`
+	Message       json.RawMessage        `json:"message,omitempty"`
+}
+
+type reporter struct {
+	sync.Mutex
+	path      string
+	decoder   proto_decoder.MessageDecoder
+	unmatched []unmatchedRequest
+	// number of times each node of the fixture has been used (keyed by node ID so that it survives reloads)
+	hits map[nodeID]int
+}
+
+// hit records that a node of the fixture was used
+func (r *reporter) hit(node *messageTree) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.hits == nil {
+		r.hits = map[nodeID]int{}
+	}
+	r.hits[node.id]++
+}
+
+func (r *reporter) used(node *messageTree) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.hits[node.id] > 0
+}
+
+func (r *reporter) message(fullMethod string, origin internal.MessageOrigin, raw []byte) reportMessage {
+	message := reportMessage{
+		MessageOrigin: origin,
+		RawMessage:    raw,
+	}
+	decoded, err := r.decoder.Decode(fullMethod, &internal.Message{
+		MessageOrigin: origin,
+		RawMessage:    raw,
+	})
+	if err == nil {
+		message.Message, _ = decoded.MarshalJSON()
+	}
+	return message
+}
+
+func (r *reporter) unmatchedRequest(fullMethod string, md metadata.MD, received [][]byte, err error, recorded bool) {
+	if r == nil {
+		return
+	}
+	request := unmatchedRequest{
+		Timestamp: time.Now(),
+		Method:    fullMethod,
+		Metadata:  md,
+		Messages:  []reportMessage{},
+		Error:     err.Error(),
+		Recorded:  recorded,
+	}
+	for _, raw := range received {
+		request.Messages = append(request.Messages, r.message(fullMethod, internal.ClientMessage, raw))
+	}
+	r.Lock()
+	r.unmatched = append(r.unmatched, request)
+	r.Unlock()
+}
+
+// unusedBranches finds the messages that were never used and the path to them from the root of each message tree
+func (r *reporter) unusedBranches(fixture fixture) []unusedBranch {
+	var keys []string
+	for key := range fixture {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	branches := []unusedBranch{}
+	for _, key := range keys {
+		fullMethod := strings.SplitN(key, " ", 2)[0]
+		var walk func(node *messageTree, path []reportMessage)
+		walk = func(node *messageTree, path []reportMessage) {
+			for _, next := range node.nextMessages {
+				message := reportMessage{
+					MessageOrigin: next.origin,
+					// a template isn't an encoded message so it's reported as it is
+					Message: json.RawMessage(next.raw),
+				}
+				if next.template == nil {
+					message = r.message(fullMethod, next.origin, []byte(next.raw))
+				}
+				nextPath := append(path[:len(path):len(path)], message)
+				if !r.used(next) {
+					branches = append(branches, unusedBranch{
+						Key:      key,
+						Messages: nextPath,
+					})
+					continue
+				}
+				walk(next, nextPath)
+			}
+		}
+		walk(fixture[key], nil)
+	}
+	return branches
+}
+
+func (r *reporter) write(fixture fixture) error {
+	r.Lock()
+	rep := report{
+		UnmatchedRequests: append([]unmatchedRequest{}, r.unmatched...),
+	}
+	r.Unlock()
+	rep.UnusedBranches = r.unusedBranches(fixture)
+
+	out, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(r.path, append(out, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "failed to write report")
+	}
+	logrus.Infof("Wrote report of %d unmatched requests and %d unused branches to %s", len(rep.UnmatchedRequests), len(rep.UnusedBranches), r.path)
+	return nil
+}
+
+// writeReport writes the report for the current fixture (if reporting is enabled)
+func (f *fixtureInterceptor) writeReport() error {
+	if f.reporter == nil {
+		return nil
+	}
+	f.RLock()
+	fixture := f.fixture
+	f.RUnlock()
+	return f.reporter.write(fixture)
+}
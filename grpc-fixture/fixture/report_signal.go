@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package fixture
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReport calls write whenever SIGUSR1 is received until the returned function is called
+func notifyReport(write func()) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			write()
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
+}
@@ -0,0 +1,7 @@
+package fixture
+
+// notifyReport does nothing as there's no SIGUSR1 on Windows:
+// the report is only written when the fixture is stopped.
+func notifyReport(write func()) (stop func()) {
+	return func() {}
+}
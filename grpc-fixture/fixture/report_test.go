@@ -0,0 +1,139 @@
+package fixture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/redact"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnusedBranches(t *testing.T) {
+	response := &messageTree{origin: internal.ServerMessage, raw: "\x08\x02", id: nodeID{1}}
+	unusedResponse := &messageTree{origin: internal.ServerMessage, raw: "\x08\x03", id: nodeID{2}}
+	request := &messageTree{origin: internal.ClientMessage, raw: "\x08\x01", id: nodeID{3}, nextMessages: []*messageTree{response, unusedResponse}}
+	unusedRequest := &messageTree{origin: internal.ClientMessage, raw: "\x08\x04", id: nodeID{4}, nextMessages: []*messageTree{
+		{origin: internal.ServerMessage, raw: "\x08\x05", id: nodeID{5}},
+	}}
+	f := fixture{
+		"/foo.Service/Get":   {id: nodeID{6}, nextMessages: []*messageTree{request, unusedRequest}},
+		"/foo.Service/Other": {id: nodeID{7}, nextMessages: []*messageTree{{origin: internal.ClientMessage, raw: "\x08\x06", id: nodeID{8}}}},
+	}
+	r := &reporter{decoder: proto_decoder.NewDecoder(logrus.New())}
+	r.hit(f["/foo.Service/Get"])
+	r.hit(request)
+	r.hit(response)
+
+	branches := r.unusedBranches(f)
+	require.Len(t, branches, 3)
+
+	require.Equal(t, "/foo.Service/Get", branches[0].Key)
+	require.Len(t, branches[0].Messages, 2)
+	require.Equal(t, []byte("\x08\x01"), branches[0].Messages[0].RawMessage)
+	require.Equal(t, []byte("\x08\x03"), branches[0].Messages[1].RawMessage)
+	require.NotEmpty(t, branches[0].Messages[1].Message)
+
+	require.Equal(t, "/foo.Service/Get", branches[1].Key)
+	require.Len(t, branches[1].Messages, 1)
+	require.Equal(t, []byte("\x08\x04"), branches[1].Messages[0].RawMessage)
+
+	require.Equal(t, "/foo.Service/Other", branches[2].Key)
+}
+
+func TestUnusedBranchesAfterReload(t *testing.T) {
+	dump, err := ioutil.TempFile("", "fixture")
+	require.NoError(t, err)
+	defer os.Remove(dump.Name())
+	encoder := json.NewEncoder(dump)
+	for _, rpc := range []*internal.RPC{
+		{
+			Service: "foo.Service",
+			Method:  "Get",
+			Messages: []*internal.Message{
+				{MessageOrigin: internal.ClientMessage, RawMessage: []byte("\x08\x01")},
+				{MessageOrigin: internal.ServerMessage, RawMessage: []byte("\x08\x02")},
+			},
+		},
+		{
+			Service: "foo.Service",
+			Method:  "Echo",
+			Messages: []*internal.Message{
+				{MessageOrigin: internal.ClientMessage, RawMessage: []byte("\x08\x03")},
+				{MessageOrigin: internal.ServerMessage, Template: true, Message: map[string]interface{}{"id": "{{.Request.id}}"}},
+			},
+		},
+	} {
+		require.NoError(t, encoder.Encode(rpc))
+	}
+	require.NoError(t, dump.Close())
+
+	noRedaction := func(_ string, _ internal.MessageOrigin, raw []byte) []byte { return raw }
+	load := func() fixture {
+		f, err := loadFixture([]string{dump.Name()}, proto_decoder.NewEncoder(), matchKey{}, noRedaction)
+		require.NoError(t, err)
+		return f
+	}
+
+	r := &reporter{decoder: proto_decoder.NewDecoder(logrus.New())}
+	f := load()
+	get := f["/foo.Service/Get"]
+	r.hit(get)
+	r.hit(get.nextMessages[0])
+	r.hit(get.nextMessages[0].nextMessages[0])
+	echo := f["/foo.Service/Echo"]
+	r.hit(echo)
+	r.hit(echo.nextMessages[0])
+
+	// the reloaded fixture has new nodes but usage is still counted
+	branches := r.unusedBranches(load())
+	require.Len(t, branches, 1)
+	require.Equal(t, "/foo.Service/Echo", branches[0].Key)
+	require.Len(t, branches[0].Messages, 2)
+	require.Equal(t, []byte("\x08\x03"), branches[0].Messages[0].RawMessage)
+	// the template is reported rather than being decoded as a message
+	require.Nil(t, branches[0].Messages[1].RawMessage)
+	require.JSONEq(t, `{"id": "{{.Request.id}}"}`, string(branches[0].Messages[1].Message))
+}
+
+func TestUnmatchedRequestRedaction(t *testing.T) {
+	request, err := builder.NewMessage("Request").
+		AddField(builder.NewField("user", builder.FieldTypeString())).
+		AddField(builder.NewField("password", builder.FieldTypeString())).
+		Build()
+	require.NoError(t, err)
+	m := dynamic.NewMessage(request)
+	m.SetFieldByName("user", "alice")
+	m.SetFieldByName("password", "hunter2")
+	raw, err := m.Marshal()
+	require.NoError(t, err)
+
+	redactor, err := redact.New([]string{"authorization"}, []string{"*.password"}, false)
+	require.NoError(t, err)
+	decoder := requestDecoder{request}
+	f := &fixtureInterceptor{
+		decoder:  decoder,
+		redactor: redactor,
+		reporter: &reporter{decoder: decoder},
+	}
+	md := metadata.Pairs("authorization", "Bearer secret", "x-tenant", "a")
+	f.reportUnmatched("/foo.Service/Login", md, [][]byte{raw}, status.Error(codes.Unavailable, "no match"), false)
+
+	require.Len(t, f.reporter.unmatched, 1)
+	reported := f.reporter.unmatched[0]
+	require.Equal(t, []string{redact.Replacement}, reported.Metadata.Get("authorization"))
+	require.Equal(t, []string{"a"}, reported.Metadata.Get("x-tenant"))
+	require.Len(t, reported.Messages, 1)
+	require.NotContains(t, string(reported.Messages[0].RawMessage), "hunter2")
+	require.NotContains(t, string(reported.Messages[0].Message), "hunter2")
+	require.Contains(t, string(reported.Messages[0].Message), "alice")
+}
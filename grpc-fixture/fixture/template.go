@@ -0,0 +1,144 @@
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// responseTemplate is a server message (in its JSON form) whose string values
+// can be templates referencing the request being replayed
+type responseTemplate struct {
+	message interface{}
+}
+
+// templateData is what templates are executed with
+type templateData struct {
+	// the latest client message (as JSON, so fields use their JSON names)
+	Request interface{}
+	// all client messages received so far
+	Requests []interface{}
+	Metadata metadata.MD
+}
+
+func newResponseTemplate(message interface{}) (*responseTemplate, error) {
+	parsed, err := parseTemplates(message)
+	if err != nil {
+		return nil, err
+	}
+	return &responseTemplate{message: parsed}, nil
+}
+
+// parseTemplates replaces all strings containing template actions with parsed templates
+func parseTemplates(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+		// referencing a field that doesn't exist is an error rather than rendering "<no value>"
+		return template.New("").Option("missingkey=error").Parse(value)
+	case map[string]interface{}:
+		parsed := map[string]interface{}{}
+		for k, v := range value {
+			p, err := parseTemplates(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid template in field %s", k)
+			}
+			parsed[k] = p
+		}
+		return parsed, nil
+	case []interface{}:
+		parsed := make([]interface{}, len(value))
+		for i, v := range value {
+			p, err := parseTemplates(v)
+			if err != nil {
+				return nil, err
+			}
+			parsed[i] = p
+		}
+		return parsed, nil
+	default:
+		return value, nil
+	}
+}
+
+// render returns the message with all templates executed
+func (t *responseTemplate) render(data templateData) (interface{}, error) {
+	return render(t.message, data)
+}
+
+func render(value interface{}, data templateData) (interface{}, error) {
+	switch value := value.(type) {
+	case *template.Template:
+		out := &bytes.Buffer{}
+		if err := value.Execute(out, data); err != nil {
+			return nil, err
+		}
+		return out.String(), nil
+	case map[string]interface{}:
+		rendered := map[string]interface{}{}
+		for k, v := range value {
+			r, err := render(v, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[k] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+		for i, v := range value {
+			r, err := render(v, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	default:
+		return value, nil
+	}
+}
+
+// renderResponse renders a templated server message and encodes it
+func (f *fixtureInterceptor) renderResponse(fullMethod string, t *responseTemplate, received [][]byte, md metadata.MD) ([]byte, error) {
+	data := templateData{
+		Metadata: md,
+	}
+	for _, raw := range received {
+		decoded, err := f.decoder.Decode(fullMethod, &internal.Message{
+			MessageOrigin: internal.ClientMessage,
+			RawMessage:    raw,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode request")
+		}
+		// fields with default values are included so that they can be referenced
+		marshalled, err := decoded.MarshalJSONPB(&jsonpb.Marshaler{EmitDefaults: true})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal request")
+		}
+		var request interface{}
+		if err := json.Unmarshal(marshalled, &request); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal request")
+		}
+		data.Requests = append(data.Requests, request)
+		data.Request = request
+	}
+
+	rendered, err := t.render(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render response template")
+	}
+	return f.encoder.Encode(fullMethod, &internal.Message{
+		MessageOrigin: internal.ServerMessage,
+		Message:       rendered,
+	})
+}
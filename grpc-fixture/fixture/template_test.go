@@ -0,0 +1,41 @@
+package fixture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestResponseTemplate(t *testing.T) {
+	tmpl, err := newResponseTemplate(map[string]interface{}{
+		"id":     "{{.Request.id}}",
+		"tenant": `{{index .Metadata "x-tenant" 0}}`,
+		"items":  []interface{}{"static", "{{len .Requests}}"},
+		"count":  float64(1),
+	})
+	require.NoError(t, err)
+
+	rendered, err := tmpl.render(templateData{
+		Request:  map[string]interface{}{"id": "abc"},
+		Requests: []interface{}{nil, nil},
+		Metadata: metadata.Pairs("x-tenant", "t1"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"id":     "abc",
+		"tenant": "t1",
+		"items":  []interface{}{"static", "2"},
+		"count":  float64(1),
+	}, rendered)
+
+	_, err = newResponseTemplate(map[string]interface{}{"id": "{{.Request.id"})
+	require.Error(t, err)
+
+	// fields missing from the request are an error rather than rendering "<no value>"
+	_, err = tmpl.render(templateData{
+		Request:  map[string]interface{}{"name": "abc"},
+		Metadata: metadata.Pairs("x-tenant", "t1"),
+	})
+	require.Error(t, err)
+}
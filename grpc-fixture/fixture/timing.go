@@ -0,0 +1,62 @@
+package fixture
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+type TimingMode string
+
+const (
+	// TimingInstant sends server messages as soon as possible
+	TimingInstant TimingMode = "instant"
+	// TimingRecorded reproduces the recorded delay between each server message and the message before it
+	TimingRecorded TimingMode = "recorded"
+)
+
+type timing struct {
+	mode TimingMode
+	// recorded delays are divided by speed (e.g. 2 plays back twice as fast)
+	speed float64
+	// delays are randomly changed by up to +/- jitter
+	jitter time.Duration
+}
+
+func (t timing) validate() error {
+	if t.mode != TimingInstant && t.mode != TimingRecorded {
+		return fmt.Errorf("unknown timing mode %q", t.mode)
+	}
+	if t.speed <= 0 {
+		return fmt.Errorf("speed must be positive, got %v", t.speed)
+	}
+	if t.jitter < 0 {
+		return fmt.Errorf("jitter must not be negative, got %v", t.jitter)
+	}
+	return nil
+}
+
+// wait blocks until delay (as adjusted by the speed and jitter) has passed since the previous message
+func (t timing) wait(ctx context.Context, previous time.Time, delay time.Duration) error {
+	if t.mode != TimingRecorded {
+		return nil
+	}
+	d := time.Duration(float64(delay) / t.speed)
+	if t.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*t.jitter))) - t.jitter
+	}
+	d -= time.Since(previous)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
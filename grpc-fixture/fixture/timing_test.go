@@ -0,0 +1,31 @@
+package fixture
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimingWait(t *testing.T) {
+	ctx := context.Background()
+	instant := timing{mode: TimingInstant, speed: 1}
+	start := time.Now()
+	require.NoError(t, instant.wait(ctx, start, time.Hour))
+	require.True(t, time.Since(start) < time.Second)
+
+	// delays are measured from the previous message and scaled by the speed
+	recorded := timing{mode: TimingRecorded, speed: 10}
+	start = time.Now()
+	require.NoError(t, recorded.wait(ctx, start, 200*time.Millisecond))
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+	require.NoError(t, recorded.wait(ctx, start.Add(-time.Second), time.Second))
+	require.True(t, time.Since(start) < time.Second)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Error(t, recorded.wait(cancelled, time.Now(), time.Hour))
+
+	require.Error(t, timing{mode: TimingRecorded, speed: 0}.validate())
+}
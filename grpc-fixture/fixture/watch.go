@@ -0,0 +1,77 @@
+package fixture
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dumpFiles expands directories in the list of dump paths to the (non-hidden) files they contain
+func dumpFiles(dumpPaths []string) ([]string, error) {
+	var files []string
+	for _, dumpPath := range dumpPaths {
+		info, err := os.Stat(dumpPath)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, dumpPath)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(dumpPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(dumpPath, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// dumpsVersion changes whenever any of the dump files are added, removed or modified
+func dumpsVersion(dumpPaths []string) string {
+	files, err := dumpFiles(dumpPaths)
+	if err != nil {
+		// try again once the dumps are readable
+		return ""
+	}
+	sort.Strings(files)
+	var version strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(&version, "%s:%d:%d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return version.String()
+}
+
+// watchDumps polls the dumps and calls reload whenever they change until stop is closed
+func watchDumps(dumpPaths []string, interval time.Duration, reload func(), stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	version := dumpsVersion(dumpPaths)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		latest := dumpsVersion(dumpPaths)
+		if latest == "" || latest == version {
+			continue
+		}
+		version = latest
+		reload()
+	}
+}
@@ -0,0 +1,76 @@
+package fixture
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dumps")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("{}"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".hidden.swp"), nil, 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0755))
+	single := filepath.Join(dir, "nested", "b.json")
+	require.NoError(t, ioutil.WriteFile(single, nil, 0644))
+
+	files, err := dumpFiles([]string{dir, single})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "a.json"), single}, files)
+
+	// the version changes when a file is modified or added
+	version := dumpsVersion([]string{dir})
+	require.NotEmpty(t, version)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("{}\n{}"), 0644))
+	modified := dumpsVersion([]string{dir})
+	require.NotEqual(t, version, modified)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "c.json"), nil, 0644))
+	require.NotEqual(t, modified, dumpsVersion([]string{dir}))
+}
+
+func TestWatchDumps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dumps")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	reloaded := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		watchDumps([]string{dir}, time.Millisecond, func() {
+			select {
+			case reloaded <- struct{}{}:
+			default:
+			}
+		}, stop)
+		close(stopped)
+	}()
+
+	// keep adding dumps until the watcher (which may not have started yet) notices
+	timeout := time.After(5 * time.Second)
+	for i := 0; ; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.json", i)), []byte("{}"), 0644))
+		select {
+		case <-reloaded:
+		case <-time.After(10 * time.Millisecond):
+			continue
+		case <-timeout:
+			t.Fatal("dumps weren't reloaded after being modified")
+		}
+		break
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchDumps didn't return after being stopped")
+	}
+}
@@ -5,20 +5,65 @@ import (
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-fixture/fixture"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
+	"github.com/bradleyjkemp/grpc-tools/internal/redact"
 	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
 	"os"
+	"strings"
+	"time"
 )
 
 func main() {
 	var (
-		dumpPath         = flag.String("dump", "", "gRPC dump to serve requests from")
+		dumpPath         = flag.String("dump", "", "gRPC dump to serve requests from. Can be a comma separated list of dump files or directories of dump files.")
+		watch            = flag.Bool("watch", false, "Reload the dumps when they change.")
 		protoRoots       = flag.String("proto_roots", "", "A comma separated list of directories to search for gRPC service definitions.")
 		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of proto descriptors to load gRPC service definitions from.")
+		matchMode        = flag.String("match_mode", "exact", "How requests are matched against the dump. Values are {exact, fields}: exact requires requests to be identical, fields compares decoded requests field by field and falls back to the closest recorded request.")
+		matchAuthority   = flag.Bool("match_authority", false, "Only match requests to RPCs recorded with the same :authority (i.e. sent to the same host).")
+		matchMetadata    = flag.String("match_metadata", "", "Comma separated list of metadata keys (e.g. x-tenant-id) that must have the same values as the recorded RPC for a request to match.")
+		playback         = flag.String("playback", "first", "How to replay different responses recorded for identical requests. Values are {first, sequential, loop}: first always replays the first response, sequential replays each response in turn and then repeats the last, loop replays each response in turn and then starts again.")
+		timingMode       = flag.String("timing", "instant", "When to send server messages. Values are {instant, recorded}: instant sends them as soon as possible, recorded reproduces the recorded delay before each message.")
+		speed            = flag.Float64("speed", 1, "Speed multiplier for --timing=recorded (e.g. 2 halves the delays).")
+		jitter           = flag.Duration("jitter", 0, "Maximum random change (+/-) to each delay with --timing=recorded.")
+		reportFile       = flag.String("report_file", "", "File to write a JSON report of unmatched requests and unused saved responses to when grpc-fixture is stopped (or receives SIGUSR1).")
+		recordMisses     = flag.Bool("record_misses", false, "Forward requests without saved responses to the real server and append them to the dump so that they're replayed next time.")
+		ignoreFields     = flag.String("ignore_fields", "", "Comma separated list of fields (glob patterns of fully qualified field names or field paths, e.g. *.request_id or header.timestamp) to ignore when matching with --match_mode=fields.")
 	)
 
 	grpc_proxy.RegisterDefaultFlags()
+	redact.RegisterFlags()
 	flag.Parse()
-	err := fixture.Run(*protoRoots, *protoDescriptors, *dumpPath, grpc_proxy.DefaultFlags())
+
+	redactor, err := redact.FromFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fixtureOptions := []fixture.Option{
+		fixture.WithRedactor(redactor),
+		fixture.WithMatchMode(fixture.MatchMode(*matchMode)),
+		fixture.WithPlayback(fixture.PlaybackMode(*playback)),
+		fixture.WithTiming(fixture.TimingMode(*timingMode), *speed, *jitter),
+	}
+	if *matchAuthority {
+		fixtureOptions = append(fixtureOptions, fixture.WithMatchAuthority())
+	}
+	if *matchMetadata != "" {
+		fixtureOptions = append(fixtureOptions, fixture.WithMatchMetadata(strings.Split(*matchMetadata, ",")))
+	}
+	if *watch {
+		fixtureOptions = append(fixtureOptions, fixture.WithWatch(time.Second))
+	}
+	if *reportFile != "" {
+		fixtureOptions = append(fixtureOptions, fixture.WithReport(*reportFile))
+	}
+	if *recordMisses {
+		fixtureOptions = append(fixtureOptions, fixture.WithRecordMisses())
+	}
+	if *ignoreFields != "" {
+		fixtureOptions = append(fixtureOptions, fixture.WithIgnoredFields(strings.Split(*ignoreFields, ",")))
+	}
+	err = fixture.RunWithOptions(*protoRoots, *protoDescriptors, *dumpPath, fixtureOptions, grpc_proxy.DefaultFlags(), grpc_proxy.StopOnInterrupt())
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		flag.Usage()
@@ -0,0 +1,58 @@
+package grpc_proxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/bradleyjkemp/grpc-tools/internal/pcapng"
+	"google.golang.org/grpc/credentials"
+)
+
+// captureListener records the (decrypted) traffic of each accepted connection
+type captureListener struct {
+	net.Listener
+	capture     *pcapng.Writer
+	description string
+}
+
+func (l captureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.capture.WrapServerConn(conn, l.description), nil
+}
+
+// captureDialer records the traffic of connections to upstream servers.
+// TLS connections are recorded by captureCredentials instead.
+func captureDialer(dialer ContextDialer, capture *pcapng.Writer) ContextDialer {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := dialer(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		return capture.WrapClientConn(conn, "upstream connection to "+address), nil
+	}
+}
+
+// captureCredentials records the decrypted traffic of TLS connections to upstream servers
+type captureCredentials struct {
+	credentials.TransportCredentials
+	capture *pcapng.Writer
+}
+
+func (c captureCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	// stop recording the encrypted traffic
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx, authority, pcapng.Unwrap(rawConn))
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.capture.WrapClientConn(conn, "decrypted TLS connection to "+authority), authInfo, nil
+}
+
+func (c captureCredentials) Clone() credentials.TransportCredentials {
+	return captureCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		capture:              c.capture,
+	}
+}
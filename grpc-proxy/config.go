@@ -1,8 +1,20 @@
 package grpc_proxy
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
 	"runtime/debug"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal/breakpoint"
+	"github.com/bradleyjkemp/grpc-tools/internal/detectcert"
+	"github.com/bradleyjkemp/grpc-tools/internal/fault"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/rewrite"
+	"github.com/bradleyjkemp/grpc-tools/internal/routing"
+	"github.com/bradleyjkemp/grpc-tools/internal/upstreamtls"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
@@ -35,9 +47,11 @@ func WithDialOptions(options ...grpc.DialOption) Configurator {
 	}
 }
 
+// WithInterceptor adds an interceptor to the proxy. It can be used multiple
+// times: the interceptors are called in the order they were added.
 func WithInterceptor(interceptor grpc.StreamServerInterceptor) Configurator {
 	return func(s *server) {
-		s.serverOptions = append(s.serverOptions, grpc.StreamInterceptor(recoverWrapper(s, interceptor)))
+		s.interceptors = append(s.interceptors, interceptor)
 	}
 }
 
@@ -53,10 +67,168 @@ func recoverWrapper(s *server, interceptor grpc.StreamServerInterceptor) grpc.St
 	}
 }
 
+// UsingTLS adds a certificate and key pair to serve TLS connections with.
+// It can be used multiple times to add several pairs: the certificate presented
+// to each client is chosen based on the requested server name.
 func UsingTLS(certFile, keyFile string) Configurator {
 	return func(s *server) {
-		s.certFile = certFile
-		s.keyFile = keyFile
+		s.keyPairs = append(s.keyPairs, detectcert.KeyPair{
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		})
+	}
+}
+
+// UsingCertificateAuthority intercepts all TLS connections by signing
+// certificates for each destination on the fly using the given CA.
+// Clients must trust the CA certificate (e.g. the mkcert root CA).
+func UsingCertificateAuthority(certFile, keyFile string) Configurator {
+	return func(s *server) {
+		s.caCertFile = certFile
+		s.caKeyFile = keyFile
+	}
+}
+
+// UpstreamTLSConfig configures the client certificate, root CA
+// and server name used when connecting to an upstream server.
+type UpstreamTLSConfig = upstreamtls.Config
+
+// WithUpstreamTLS sets the TLS config to use when dialing upstream servers
+// matching the destination pattern. Patterns are either a host, a host:port
+// or a glob (e.g. *.example.com) and an empty pattern matches all destinations.
+// Destinations matching a non-empty pattern are always dialed using TLS
+// whereas the config for the empty pattern is only used for clients using TLS.
+func WithUpstreamTLS(destination string, config UpstreamTLSConfig) Configurator {
+	return func(s *server) {
+		if err := s.upstreamTLS.Add(destination, config); err != nil {
+			s.configErr = err
+		}
+	}
+}
+
+// RequestClientCertificates makes the proxy ask clients for a certificate when
+// intercepting TLS connections. The certificate is not verified but is available
+// to interceptors using peer.FromContext and can be mapped to a certificate to
+// present to upstream servers using WithClientIdentity.
+func RequestClientCertificates() Configurator {
+	return func(s *server) {
+		s.requestClientCerts = true
+	}
+}
+
+// WithClientIdentity presents the client certificate in config to upstream servers
+// for requests from clients that presented a certificate matching the identity.
+// The identity is either the SHA-256 fingerprint, subject or common name of the
+// client's certificate. This implies RequestClientCertificates.
+func WithClientIdentity(identity string, config UpstreamTLSConfig) Configurator {
+	return func(s *server) {
+		s.requestClientCerts = true
+		if err := s.clientIdentities.Add(identity, config); err != nil {
+			s.configErr = err
+		}
+	}
+}
+
+// WithPcapngFile writes the decrypted traffic of all intercepted connections
+// (and the connections to upstream servers) to a pcapng file.
+func WithPcapngFile(path string) Configurator {
+	return func(s *server) {
+		s.pcapngFile = path
+	}
+}
+
+// FaultRule describes faults to inject into the RPCs matching its service, method and metadata
+type FaultRule = fault.Rule
+
+// WithFaults injects faults (errors, latency, dropped connections, truncated streams
+// and corrupted messages) into matching RPCs. For each RPC, the first matching rule
+// picked according to its probability is applied. Faults are injected outside of any other
+// interceptors so, for example, grpc-dump records the real server's responses.
+func WithFaults(rules ...FaultRule) Configurator {
+	return func(s *server) {
+		for _, rule := range rules {
+			if err := rule.Validate(); err != nil {
+				s.configErr = err
+				return
+			}
+		}
+		s.faultRules = append(s.faultRules, rules...)
+	}
+}
+
+// RewriteRule describes how to rewrite the messages and metadata of RPCs matching its service and method
+type RewriteRule = rewrite.Rule
+
+// WithRewrites rewrites the messages and metadata of matching RPCs before they're forwarded.
+// All matching rules are applied in order. Messages are decoded using the decoder set by
+// WithMessageDecoder or, by default, without any proto definitions (so fields must be
+// referred to by number and only fields present in a message can be set).
+func WithRewrites(rules ...RewriteRule) Configurator {
+	return func(s *server) {
+		for _, rule := range rules {
+			if err := rule.Validate(); err != nil {
+				s.configErr = err
+				return
+			}
+		}
+		s.rewriteRules = append(s.rewriteRules, rules...)
+	}
+}
+
+// WithMessageDecoder sets the decoder used to decode messages for rewriting
+func WithMessageDecoder(decoder proto_decoder.MessageDecoder) Configurator {
+	return func(s *server) {
+		s.decoder = decoder
+	}
+}
+
+// Route forwards RPCs matching its service, method, authority and metadata to a specific upstream server
+type Route = routing.Route
+
+// WithRoutes forwards RPCs to the destination of the first matching route instead of the
+// --destination flag or the :authority of the RPC (which are used if no route matches).
+// The route each RPC was forwarded to is available to interceptors using ForwardedRoute.
+func WithRoutes(routes ...Route) Configurator {
+	return func(s *server) {
+		for _, route := range routes {
+			if err := route.Validate(); err != nil {
+				s.configErr = err
+				return
+			}
+		}
+		s.routes = append(s.routes, routes...)
+	}
+}
+
+// WithMirror also sends each RPC forwarded by the proxy to a mirror (shadow) server, e.g. to
+// compare a new version of a service with live traffic. The mirror's responses are discarded
+// but are available to interceptors using WaitForMirror. If plaintext is set, the mirror is
+// connected to without TLS (otherwise TLS is used in the same way as for other destinations).
+func WithMirror(destination string, plaintext bool) Configurator {
+	return func(s *server) {
+		s.mirror = &mirrorConfig{
+			destination: destination,
+			plaintext:   plaintext,
+		}
+	}
+}
+
+// Breakpoint holds the client or server messages of RPCs matching its service and method patterns
+type Breakpoint = breakpoint.Breakpoint
+
+// WithBreakpoints holds the messages of matching RPCs until they're released (optionally
+// edited) or dropped using the HTTP API served on addr (see the grpc-dump README).
+// Messages are decoded for editing using the decoder set by WithMessageDecoder.
+func WithBreakpoints(addr string, breakpoints ...Breakpoint) Configurator {
+	return func(s *server) {
+		for _, b := range breakpoints {
+			if err := b.Validate(); err != nil {
+				s.configErr = err
+				return
+			}
+		}
+		s.breakpointAddr = addr
+		s.breakpoints = append(s.breakpoints, breakpoints...)
 	}
 }
 
@@ -72,27 +244,113 @@ func WithDialer(dialer ContextDialer) Configurator {
 	}
 }
 
+// StopOnInterrupt stops the proxy (see Stop) when the process receives SIGINT or SIGTERM
+// so that Start returns and the caller can clean up (e.g. finish writing output) before exiting.
+// This is always done when the system proxy is enabled so that it's disabled again.
+func StopOnInterrupt() Configurator {
+	return func(s *server) {
+		s.stopOnInterrupt = true
+	}
+}
+
+// StopWhenDone stops the proxy (see Stop) once ctx is done. This is useful when the
+// proxy is started by another function (e.g. dump.Run) so Stop can't be called directly.
+func StopWhenDone(ctx context.Context) Configurator {
+	return func(s *server) {
+		s.stopWhenDone = ctx
+	}
+}
+
+// WithLogOutput writes the proxy's logs to w instead of stderr
+func WithLogOutput(w io.Writer) Configurator {
+	return func(s *server) {
+		s.logOutput = w
+	}
+}
+
 var (
 	fNetworkInterface  string
 	fPort              int
 	fCertFile          string
 	fKeyFile           string
+	fCACertFile        string
+	fCAKeyFile         string
 	fDestination       string
 	fLogLevel          string
 	fEnableSystemProxy bool
 	fTLSSecretsFile    string
+	fPcapngFile        string
+	fUpstreamCertFile  string
+	fUpstreamKeyFile   string
+	fUpstreamCAFile    string
+	fUpstreamTLSName   string
+	fUpstreamTLSConfig string
+	fRequestClientCert bool
+	fClientIdentities  string
+	fFaultConfig       string
+	fRewriteConfig     string
+	fRouteConfig       string
+	fMirror            string
+	fMirrorPlaintext   bool
+	fBreakpoints       string
+	fBreakpointAddr    string
 )
 
 // Must be called before flag.Parse() if using the DefaultFlags option
 func RegisterDefaultFlags() {
 	flag.StringVar(&fNetworkInterface, "interface", "localhost", "Network interface to listen on. By default listens on the localhost interface.")
 	flag.IntVar(&fPort, "port", 0, "Port to listen on.")
-	flag.StringVar(&fCertFile, "cert", "", "Certificate file to use for serving using TLS. By default the current directory will be scanned for mkcert certificates to use.")
-	flag.StringVar(&fKeyFile, "key", "", "Key file to use for serving using TLS. By default the current directory will be scanned for mkcert keys to use.")
+	flag.StringVar(&fCertFile, "cert", "", "Comma separated list of certificate files to use for serving using TLS. By default the current directory will be scanned for mkcert certificates to use.")
+	flag.StringVar(&fKeyFile, "key", "", "Comma separated list of key files (in the same order as --cert) to use for serving using TLS. By default the current directory will be scanned for mkcert keys to use.")
+	flag.StringVar(&fCACertFile, "ca_cert", "", "CA certificate file (e.g. mkcert's rootCA.pem) to sign certificates with so that connections to any domain can be intercepted.")
+	flag.StringVar(&fCAKeyFile, "ca_key", "", "CA key file (e.g. mkcert's rootCA-key.pem) to sign certificates with so that connections to any domain can be intercepted.")
 	flag.StringVar(&fDestination, "destination", "", "Destination server to forward requests to if no destination can be inferred from the request itself. This is generally only used for clients not supporting HTTP proxies.")
 	flag.StringVar(&fLogLevel, "log_level", logrus.InfoLevel.String(), "Set the log level that grpc-proxy will log at. Values are {error, warning, info, debug}")
 	flag.BoolVar(&fEnableSystemProxy, "system_proxy", false, "Automatically configure system to use this as the proxy for all connections.")
 	flag.StringVar(&fTLSSecretsFile, "tls_secrets_file", "", "Secrets file to write the TLS master secrets in order to decrypt TLS traffic with different tools such as Wireshark.")
+	flag.StringVar(&fPcapngFile, "pcapng_file", "", "File to write a packet capture of the decrypted traffic of all intercepted connections to (which can be opened in Wireshark).")
+	flag.BoolVar(&fRequestClientCert, "request_client_certs", false, "Ask clients for a certificate when intercepting TLS connections and record it in the dump.")
+	flag.StringVar(&fClientIdentities, "client_identities", "", "JSON file mapping client certificate identities (SHA-256 fingerprint, subject or common name) to the \"cert\" and \"key\" to present to upstream servers for that client. Implies --request_client_certs.")
+	flag.StringVar(&fFaultConfig, "fault_config", "", "JSON file containing a list of rules for injecting faults into matching RPCs (see the grpc-dump README).")
+	flag.StringVar(&fRewriteConfig, "rewrite_config", "", "JSON file containing a list of rules for rewriting the messages and metadata of matching RPCs (see the grpc-dump README).")
+	flag.StringVar(&fRouteConfig, "route_config", "", "JSON file containing a list of routes forwarding matching RPCs to specific upstream servers (see the grpc-dump README).")
+	flag.StringVar(&fMirror, "mirror", "", "Mirror (shadow) server (host:port) to also send each RPC to. Its responses are discarded but are recorded in the dump and compared with the real responses.")
+	flag.BoolVar(&fMirrorPlaintext, "mirror_plaintext", false, "Connect to the --mirror server without TLS (e.g. a local build of a service).")
+	flag.StringVar(&fBreakpoints, "breakpoints", "", "Comma separated list of method patterns (e.g. payments.*/Charge) whose messages are held until released, edited or dropped using the breakpoint API. Patterns can be suffixed with :request or :response to only hold those messages.")
+	flag.StringVar(&fBreakpointAddr, "breakpoint_addr", "localhost:0", "Address for the breakpoint API to listen on (port 0 picks a random port which is logged).")
+	RegisterUpstreamTLSFlags()
+}
+
+// RegisterUpstreamTLSFlags registers the flags for configuring TLS connections
+// to upstream servers. It is called by RegisterDefaultFlags.
+func RegisterUpstreamTLSFlags() {
+	flag.StringVar(&fUpstreamCertFile, "upstream_cert", "", "Client certificate file to present when connecting to upstream servers using TLS.")
+	flag.StringVar(&fUpstreamKeyFile, "upstream_key", "", "Client key file to use when connecting to upstream servers using TLS.")
+	flag.StringVar(&fUpstreamCAFile, "upstream_ca", "", "CA certificate file to verify upstream servers with instead of the system roots.")
+	flag.StringVar(&fUpstreamTLSName, "upstream_server_name", "", "Server name to verify upstream servers certificates against (and to send as SNI).")
+	flag.StringVar(&fUpstreamTLSConfig, "upstream_tls_config", "", "JSON file mapping upstream destination patterns to TLS configs with \"cert\", \"key\", \"ca\" and \"server_name\" fields.")
+}
+
+// UpstreamTLSFlags returns the upstream TLS configs set by the flags
+// registered with RegisterUpstreamTLSFlags. This must be used after a call to flag.Parse()
+func UpstreamTLSFlags() (map[string]UpstreamTLSConfig, error) {
+	configs := map[string]UpstreamTLSConfig{}
+	if fUpstreamTLSConfig != "" {
+		var err error
+		configs, err = upstreamtls.LoadConfigFile(fUpstreamTLSConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fUpstreamCertFile != "" || fUpstreamKeyFile != "" || fUpstreamCAFile != "" || fUpstreamTLSName != "" {
+		configs[""] = UpstreamTLSConfig{
+			CertFile:   fUpstreamCertFile,
+			KeyFile:    fUpstreamKeyFile,
+			RootCAFile: fUpstreamCAFile,
+			ServerName: fUpstreamTLSName,
+		}
+	}
+	return configs, nil
 }
 
 // This must be used after a call to flag.Parse()
@@ -100,10 +358,80 @@ func DefaultFlags() Configurator {
 	return func(s *server) {
 		s.networkInterface = fNetworkInterface
 		s.port = fPort
-		s.certFile = fCertFile
-		s.keyFile = fKeyFile
+		if fCertFile != "" || fKeyFile != "" {
+			certFiles := strings.Split(fCertFile, ",")
+			keyFiles := strings.Split(fKeyFile, ",")
+			if len(certFiles) != len(keyFiles) {
+				s.configErr = fmt.Errorf("--cert and --key must have the same number of files (got %d and %d)", len(certFiles), len(keyFiles))
+				return
+			}
+			for i := range certFiles {
+				UsingTLS(certFiles[i], keyFiles[i])(s)
+			}
+		}
+		s.caCertFile = fCACertFile
+		s.caKeyFile = fCAKeyFile
 		s.destination = fDestination
 		s.enableSystemProxy = fEnableSystemProxy
 		s.tlsSecretsFile = fTLSSecretsFile
+		s.pcapngFile = fPcapngFile
+
+		upstreamConfigs, err := UpstreamTLSFlags()
+		if err != nil {
+			s.configErr = err
+			return
+		}
+		for destination, config := range upstreamConfigs {
+			WithUpstreamTLS(destination, config)(s)
+		}
+
+		if fRequestClientCert {
+			RequestClientCertificates()(s)
+		}
+		if fClientIdentities != "" {
+			identities, err := upstreamtls.LoadConfigFile(fClientIdentities)
+			if err != nil {
+				s.configErr = err
+				return
+			}
+			for identity, config := range identities {
+				WithClientIdentity(identity, config)(s)
+			}
+		}
+		if fFaultConfig != "" {
+			rules, err := fault.LoadRules(fFaultConfig)
+			if err != nil {
+				s.configErr = err
+				return
+			}
+			WithFaults(rules...)(s)
+		}
+		if fRewriteConfig != "" {
+			rules, err := rewrite.LoadRules(fRewriteConfig)
+			if err != nil {
+				s.configErr = err
+				return
+			}
+			WithRewrites(rules...)(s)
+		}
+		if fRouteConfig != "" {
+			routes, err := routing.LoadRoutes(fRouteConfig)
+			if err != nil {
+				s.configErr = err
+				return
+			}
+			WithRoutes(routes...)(s)
+		}
+		if fMirror != "" {
+			WithMirror(fMirror, fMirrorPlaintext)(s)
+		}
+		if fBreakpoints != "" {
+			breakpoints, err := breakpoint.Parse(fBreakpoints)
+			if err != nil {
+				s.configErr = err
+				return
+			}
+			WithBreakpoints(fBreakpointAddr, breakpoints...)(s)
+		}
 	}
 }
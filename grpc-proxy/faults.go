@@ -0,0 +1,81 @@
+package grpc_proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// chainInterceptors combines interceptors into one (grpc.ChainStreamInterceptor
+// isn't available in the version of gRPC used). The first interceptor is the outermost.
+func chainInterceptors(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// connections tracks the open client connections (keyed by remote address)
+// so that injected faults can drop the connection an RPC was made on.
+type connections struct {
+	sync.Mutex
+	conns map[string]net.Conn
+}
+
+func newConnections() *connections {
+	return &connections{
+		conns: map[string]net.Conn{},
+	}
+}
+
+// close closes the connection of the client making the RPC with this context
+func (c *connections) close(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return fmt.Errorf("client address unknown")
+	}
+	c.Lock()
+	conn, ok := c.conns[p.Addr.String()]
+	c.Unlock()
+	if !ok {
+		return fmt.Errorf("no open connection from %s", p.Addr)
+	}
+	return conn.Close()
+}
+
+type trackingListener struct {
+	net.Listener
+	connections *connections
+}
+
+func (l trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.connections.Lock()
+	l.connections.conns[conn.RemoteAddr().String()] = conn
+	l.connections.Unlock()
+	return &trackedConn{conn, l.connections}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	connections *connections
+}
+
+func (c *trackedConn) Close() error {
+	c.connections.Lock()
+	delete(c.connections.conns, c.RemoteAddr().String())
+	c.connections.Unlock()
+	return c.Conn.Close()
+}
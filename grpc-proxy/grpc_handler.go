@@ -2,17 +2,19 @@ package grpc_proxy
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 
 	"github.com/bradleyjkemp/grpc-tools/internal/codec"
 	"github.com/bradleyjkemp/grpc-tools/internal/marker"
+	"github.com/bradleyjkemp/grpc-tools/internal/routing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -28,29 +30,22 @@ func (s *server) proxyHandler(srv interface{}, ss grpc.ServerStream) error {
 		return status.Error(codes.Unknown, "could not extract metadata from request")
 	}
 
-	options := append(s.dialOptions,
-		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec.NoopCodec{})),
-		grpc.WithBlock(),
-	)
-	if marker.IsTLSRPC(md) {
-		options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(nil)))
-	} else {
-		options = append(options, grpc.WithInsecure())
+	// little bit of gRPC internals never hurt anyone
+	fullMethodName, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Errorf(codes.Internal, "no method exists in context")
 	}
 
-	destinationAddr, err := s.calculateDestination(md)
+	destinationAddr, route, err := s.calculateDestination(fullMethodName, md)
 	if err != nil {
 		return err
 	}
-	destination, err := s.connPool.GetClientConn(ss.Context(), destinationAddr, options...)
+	setRoute(ss.Context(), route, destinationAddr)
+
+	destination, err := s.dial(ss.Context(), md, destinationAddr, route != nil && route.Plaintext)
 	if err != nil {
 		return err
 	}
-	// little bit of gRPC internals never hurt anyone
-	fullMethodName, ok := grpc.MethodFromServerStream(ss)
-	if !ok {
-		return status.Errorf(codes.Internal, "no method exists in context")
-	}
 
 	clientCtx, clientCancel := getClientCtx(ss.Context())
 	clientStream, err := destination.NewStream(clientCtx, proxyStreamDesc, fullMethodName)
@@ -58,10 +53,15 @@ func (s *server) proxyHandler(srv interface{}, ss grpc.ServerStream) error {
 		return err
 	}
 
+	var mirror *mirrorQueue
+	if s.mirror != nil {
+		mirror = s.startMirror(ss.Context(), fullMethodName, md)
+	}
+
 	// Explicitly *do not close* s2cErrChan and c2sErrChan, otherwise the select below will not terminate.
 	// Channels do not have to be closed, it is just a control flow mechanism, see
 	// https://groups.google.com/forum/#!msg/golang-nuts/pZwdYRGxCIk/qpbHxRRPJdUJ
-	s2cErrChan := forwardServerToClient(ss, clientStream)
+	s2cErrChan := forwardServerToClient(ss, clientStream, mirror)
 	c2sErrChan := forwardClientToServer(clientStream, ss)
 	// We don't know which side is going to stop sending first, so we need a select between the two.
 	for i := 0; i < 2; i++ {
@@ -77,7 +77,7 @@ func (s *server) proxyHandler(srv interface{}, ss grpc.ServerStream) error {
 				// to cancel the clientStream to the backend, let all of its goroutines be freed up by the CancelFunc and
 				// exit with an error to the stack
 				clientCancel()
-				fmt.Fprintln(os.Stderr, "failed proxying s2c", s2cErr)
+				s.logger.WithError(s2cErr).Warn("Failed proxying s2c")
 				return grpc.Errorf(codes.Internal, "failed proxying s2c: %v", s2cErr)
 			}
 		case c2sErr := <-c2sErrChan:
@@ -95,10 +95,43 @@ func (s *server) proxyHandler(srv interface{}, ss grpc.ServerStream) error {
 	return status.Errorf(codes.Internal, "gRPC proxying should never reach this stage.")
 }
 
-func (s *server) calculateDestination(md metadata.MD) (string, error) {
+// dial returns a (possibly pooled) connection to the destination using TLS if the client
+// used TLS or there's a TLS config specific to the destination (unless plaintext is set)
+func (s *server) dial(ctx context.Context, md metadata.MD, destinationAddr string, plaintext bool) (*grpc.ClientConn, error) {
+	options := append(s.dialOptions,
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec.NoopCodec{})),
+		grpc.WithBlock(),
+	)
+	tlsConfig, useTLS := s.upstreamTLS.For(destinationAddr)
+	// connections presenting a client identity must not be shared with other clients
+	connKey := destinationAddr
+	if identity, identityConfig, ok := s.clientIdentities.For(ClientCertificate(ctx), tlsConfig); ok {
+		tlsConfig = identityConfig
+		connKey = fmt.Sprintf("%s (as %s)", destinationAddr, identity)
+	}
+	if plaintext {
+		options = append(options, grpc.WithInsecure())
+		connKey += " (plaintext)"
+	} else if marker.IsTLSRPC(md) || useTLS {
+		var creds credentials.TransportCredentials = credentials.NewTLS(tlsConfig)
+		if s.capture != nil {
+			creds = captureCredentials{creds, s.capture}
+		}
+		options = append(options, grpc.WithTransportCredentials(creds))
+	} else {
+		options = append(options, grpc.WithInsecure())
+	}
+	return s.connPool.GetKeyedClientConn(ctx, connKey, destinationAddr, options...)
+}
+
+func (s *server) calculateDestination(fullMethod string, md metadata.MD) (string, *routing.Route, error) {
 	authority := md.Get(":authority")
+	route := routing.Find(s.routes, fullMethod, md)
 	var destinationAddr string
 	switch {
+	case route != nil:
+		destinationAddr = route.Destination
+
 	case s.destination != "":
 		// used hardcoded destination if set (used by clients not supporting HTTP proxies)
 		destinationAddr = s.destination
@@ -109,7 +142,7 @@ func (s *server) calculateDestination(md metadata.MD) (string, error) {
 
 	default:
 		// no destination can be determined so just error
-		return "", status.Error(codes.Unimplemented, "no proxy destination configured")
+		return "", nil, status.Error(codes.Unimplemented, "no proxy destination configured")
 	}
 
 	// if this a gRPC-Web connection then it doesn't have a port so we add the default
@@ -122,10 +155,24 @@ func (s *server) calculateDestination(md metadata.MD) (string, error) {
 	}
 
 	if err := marker.AddLoopCheck(md, s.listener.Addr().String()); err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return destinationAddr, nil
+	return destinationAddr, route, nil
+}
+
+// ClientCertificate returns the certificate the client presented to the proxy (if any).
+// Clients are only asked for certificates when using RequestClientCertificates.
+func ClientCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil
+	}
+	return tlsInfo.State.PeerCertificates[0]
 }
 
 func getClientCtx(serverCtx context.Context) (context.Context, context.CancelFunc) {
@@ -171,15 +218,18 @@ func forwardClientToServer(src grpc.ClientStream, dst grpc.ServerStream) chan er
 	return ret
 }
 
-func forwardServerToClient(src grpc.ServerStream, dst grpc.ClientStream) chan error {
+// forwardServerToClient also copies the messages to mirror (if not nil) without blocking
+func forwardServerToClient(src grpc.ServerStream, dst grpc.ClientStream, mirror *mirrorQueue) chan error {
 	ret := make(chan error, 1)
 	go func() {
+		defer mirror.close()
 		var f []byte
 		for i := 0; ; i++ {
 			if err := src.RecvMsg(&f); err != nil {
 				ret <- err // this can be io.EOF which is happy case
 				break
 			}
+			mirror.send(f)
 			if err := dst.SendMsg(f); err != nil {
 				ret <- err
 				break
@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/bradleyjkemp/grpc-tools/internal/marker"
+	"github.com/bradleyjkemp/grpc-tools/internal/pcapng"
+	"github.com/bradleyjkemp/grpc-tools/internal/tlsmux"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/http2"
@@ -60,7 +62,8 @@ func handleConnect(w http.ResponseWriter, r *http.Request, internalRedirect func
 	}
 	_, err = fmt.Fprintf(clientConn, "%s 200 OK\r\n\r\n", r.Proto)
 	if err == nil {
-		internalRedirect(clientConn, r.Host)
+		// the tunnelled connection is recorded separately (if it's intercepted)
+		internalRedirect(pcapng.Unwrap(clientConn), r.Host)
 	} else {
 		_ = clientConn.Close()
 	}
@@ -100,10 +103,18 @@ func isGrpcRequest(server grpcWebServer, r *http.Request) bool {
 //
 // It also adds a wrapper to enable HTTP2 on "unencrypted" connections.
 // (not actually unencrypted because we're using a TLS listener)
-func withHttpsMiddleware(server *http.Server) *http.Server {
+//
+// If states is non-nil, the TLS state of the connection is added to the
+// request so that the gRPC server makes it available via peer.FromContext.
+func withHttpsMiddleware(server *http.Server, states *tlsmux.ConnectionStates) *http.Server {
 	wrappedHandler := server.Handler
 	server.Handler = h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		marker.AddHTTPSMarker(r.Header)
+		if states != nil {
+			if state, ok := states.Get(r.RemoteAddr); ok {
+				r.TLS = &state
+			}
+		}
 		wrappedHandler.ServeHTTP(w, r)
 	}), &http2.Server{})
 
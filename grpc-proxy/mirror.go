@@ -0,0 +1,239 @@
+package grpc_proxy
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// client messages are queued for the mirror so that a slow mirror doesn't slow down the RPC
+	mirrorQueueSize = 1000
+	// how long the mirror has to finish after the RPC has finished before it's cancelled
+	mirrorTimeout = 10 * time.Second
+)
+
+type mirrorConfig struct {
+	destination string
+	plaintext   bool
+}
+
+type mirrorKey struct{}
+
+// mirrorHolder is where the proxy handler records the mirror's response to an RPC
+type mirrorHolder struct {
+	sync.Mutex
+	started bool
+	// set if client messages weren't sent to the mirror because it fell too far behind
+	dropped bool
+	done    chan struct{}
+	result  *internal.Mirror
+}
+
+// mirrorQueue queues the client's messages to be sent to the mirror.
+// Its methods do nothing if it's nil (i.e. the RPC isn't being mirrored).
+type mirrorQueue struct {
+	messages   chan []byte
+	holder     *mirrorHolder
+	logger     logrus.FieldLogger
+	fullMethod string
+}
+
+// send queues a client message without blocking
+func (q *mirrorQueue) send(message []byte) {
+	if q == nil {
+		return
+	}
+	select {
+	case q.messages <- message:
+		return
+	default:
+	}
+	// the mirror has fallen too far behind so will get a different stream of messages
+	q.holder.Lock()
+	defer q.holder.Unlock()
+	if !q.holder.dropped {
+		q.logger.WithField("method", q.fullMethod).Warn("Mirror fell behind so client messages were dropped, its response won't be compared")
+	}
+	q.holder.dropped = true
+}
+
+// close is called once there are no more client messages
+func (q *mirrorQueue) close() {
+	if q != nil {
+		close(q.messages)
+	}
+}
+
+// recordMirrors is the outermost interceptor when mirroring so that
+// the mirror's responses are available to all interceptors.
+func recordMirrors(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	holder := &mirrorHolder{done: make(chan struct{})}
+	err := handler(srv, &contextServerStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), mirrorKey{}, holder),
+	})
+	holder.Lock()
+	if !holder.started {
+		// the RPC was never forwarded so there's nothing to wait for
+		close(holder.done)
+	}
+	holder.Unlock()
+	return err
+}
+
+// Mirrored returns whether RPCs are being mirrored (see WithMirror)
+// i.e. whether WaitForMirror may have to wait.
+func Mirrored(ctx context.Context) bool {
+	_, ok := ctx.Value(mirrorKey{}).(*mirrorHolder)
+	return ok
+}
+
+// WaitForMirror waits for the mirror (see WithMirror) to finish responding to an RPC
+// and returns its response. This must only be called once the RPC has been handled.
+// It returns nil if the RPC wasn't mirrored.
+func WaitForMirror(ctx context.Context) *internal.Mirror {
+	holder, ok := ctx.Value(mirrorKey{}).(*mirrorHolder)
+	if !ok {
+		return nil
+	}
+	<-holder.done
+	holder.Lock()
+	defer holder.Unlock()
+	if holder.result != nil && holder.dropped {
+		holder.result.Incomplete = true
+	}
+	return holder.result
+}
+
+// startMirror sends the RPC to the mirror and returns the queue to send the client's messages to
+// (which must be closed). The mirror's responses are discarded once they've been recorded.
+func (s *server) startMirror(ctx context.Context, fullMethod string, md metadata.MD) *mirrorQueue {
+	holder, ok := ctx.Value(mirrorKey{}).(*mirrorHolder)
+	if !ok {
+		return nil
+	}
+	holder.Lock()
+	holder.started = true
+	holder.Unlock()
+
+	// the mirror mustn't be cancelled as soon as the RPC finishes as it's likely to be slightly behind
+	// (but the context keeps the client's details e.g. to present the same client identity)
+	mirrorCtx, cancel := context.WithCancel(metadata.NewOutgoingContext(detachedContext{ctx}, md))
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-finished:
+			return
+		}
+		select {
+		case <-time.After(mirrorTimeout):
+			cancel()
+		case <-finished:
+		}
+	}()
+
+	messages := make(chan []byte, mirrorQueueSize)
+	go func() {
+		defer cancel()
+		defer close(holder.done)
+		defer close(finished)
+		holder.result = s.mirrorRPC(mirrorCtx, fullMethod, md, messages)
+		if holder.result.Status != nil {
+			s.logger.WithField("method", fullMethod).Debugf("Mirror RPC failed: %s", holder.result.Status.Message)
+		}
+	}()
+	return &mirrorQueue{
+		messages:   messages,
+		holder:     holder,
+		logger:     s.logger,
+		fullMethod: fullMethod,
+	}
+}
+
+func (s *server) mirrorRPC(ctx context.Context, fullMethod string, md metadata.MD, messages <-chan []byte) *internal.Mirror {
+	result := &internal.Mirror{
+		Destination: s.mirror.destination,
+		Messages:    []*internal.Message{},
+	}
+	conn, err := s.dial(ctx, md, s.mirror.destination, s.mirror.plaintext)
+	if err != nil {
+		result.Status = &internal.Status{
+			Code:    codes.Unavailable.String(),
+			Message: err.Error(),
+		}
+		return result
+	}
+	stream, err := conn.NewStream(ctx, proxyStreamDesc, fullMethod)
+	if err != nil {
+		result.Status = mirrorStatus(err)
+		return result
+	}
+
+	go func() {
+		for message := range messages {
+			if err := stream.SendMsg(message); err != nil {
+				break
+			}
+		}
+		_ = stream.CloseSend()
+	}()
+
+	for {
+		var message []byte
+		err := stream.RecvMsg(&message)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Status = mirrorStatus(err)
+			break
+		}
+		result.Messages = append(result.Messages, &internal.Message{
+			MessageOrigin: internal.ServerMessage,
+			RawMessage:    message,
+			Timestamp:     time.Now(),
+		})
+	}
+	result.MetadataRespHeaders, _ = stream.Header()
+	result.MetadataRespTrailers = stream.Trailer()
+	return result
+}
+
+func mirrorStatus(err error) *internal.Status {
+	st := status.Convert(err)
+	return &internal.Status{
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	}
+}
+
+// detachedContext has the values of its parent but isn't cancelled with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
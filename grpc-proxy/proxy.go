@@ -5,18 +5,30 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/breakpoint"
+	"github.com/bradleyjkemp/grpc-tools/internal/ca"
 	"github.com/bradleyjkemp/grpc-tools/internal/codec"
 	"github.com/bradleyjkemp/grpc-tools/internal/detectcert"
+	"github.com/bradleyjkemp/grpc-tools/internal/fault"
+	"github.com/bradleyjkemp/grpc-tools/internal/pcapng"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/bradleyjkemp/grpc-tools/internal/proxy_settings"
 	"github.com/bradleyjkemp/grpc-tools/internal/proxydialer"
+	"github.com/bradleyjkemp/grpc-tools/internal/rewrite"
+	"github.com/bradleyjkemp/grpc-tools/internal/routing"
 	"github.com/bradleyjkemp/grpc-tools/internal/tlsmux"
+	"github.com/bradleyjkemp/grpc-tools/internal/upstreamtls"
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -25,30 +37,66 @@ import (
 	_ "google.golang.org/grpc/encoding/gzip"
 )
 
+// how long connections have to become idle once the proxy has stopped before they're closed
+const shutdownTimeout = 5 * time.Second
+
 type ContextDialer = func(context.Context, string) (net.Conn, error)
 
 type server struct {
 	serverOptions []grpc.ServerOption
+	interceptors  []grpc.StreamServerInterceptor
 	grpcServer    *grpc.Server
 	logger        logrus.FieldLogger
+	logOutput     io.Writer
 
 	networkInterface string
 	port             int
-	certFile         string
-	keyFile          string
-	x509Cert         *x509.Certificate
-	tlsCert          tls.Certificate
+	keyPairs         []detectcert.KeyPair
+	tlsCerts         []tls.Certificate
+	caCertFile       string
+	caKeyFile        string
+	authority        *ca.Authority
 
 	destination string
 	connPool    *internal.ConnPool
 	dialOptions []grpc.DialOption
 	dialer      ContextDialer
+	upstreamTLS *upstreamtls.Configs
+
+	requestClientCerts bool
+	connectionStates   *tlsmux.ConnectionStates
+	clientIdentities   *upstreamtls.Identities
 
 	enableSystemProxy bool
 
 	tlsSecretsFile string
 
+	pcapngFile  string
+	captureFile *os.File
+	capture     *pcapng.Writer
+
+	faultRules  []fault.Rule
+	connections *connections
+
+	rewriteRules []rewrite.Rule
+	decoder      proto_decoder.MessageDecoder
+
+	routes []routing.Route
+	mirror *mirrorConfig
+
+	breakpoints    []breakpoint.Breakpoint
+	breakpointAddr string
+	debugger       *breakpoint.Debugger
+
 	listener net.Listener
+
+	stopOnInterrupt bool
+	stopWhenDone    context.Context
+	stopped         chan struct{}
+	stop            sync.Once
+
+	// configErr is set by configurators that fail to apply (e.g. due to invalid flags)
+	configErr error
 }
 
 func New(configurators ...Configurator) (*server, error) {
@@ -57,6 +105,9 @@ func New(configurators ...Configurator) (*server, error) {
 		logger:           logger,
 		dialer:           proxydialer.NewProxyDialer(httpproxy.FromEnvironment().ProxyFunc()),
 		networkInterface: "localhost", // default to just localhost if no other interface is chosen
+		upstreamTLS:      &upstreamtls.Configs{},
+		clientIdentities: &upstreamtls.Identities{},
+		stopped:          make(chan struct{}),
 	}
 	s.serverOptions = []grpc.ServerOption{
 		grpc.MaxRecvMsgSize(64 * 1024 * 1024),      // Up the max message size from 4MB to 64MB (to give headroom for intercepting services who've upped theirs)
@@ -67,6 +118,57 @@ func New(configurators ...Configurator) (*server, error) {
 	for _, configurator := range configurators {
 		configurator(s)
 	}
+	if s.configErr != nil {
+		return nil, s.configErr
+	}
+	if s.logOutput != nil {
+		logger.SetOutput(s.logOutput)
+	}
+
+	interceptors := s.interceptors
+	if s.decoder == nil && (len(s.rewriteRules) > 0 || len(s.breakpoints) > 0) {
+		s.decoder = proto_decoder.NewDecoder(logger)
+	}
+	if len(s.breakpoints) > 0 {
+		// inside the rewriter so that held requests have already been rewritten
+		s.debugger = breakpoint.NewDebugger(logger, s.decoder, s.breakpoints)
+		interceptors = append([]grpc.StreamServerInterceptor{s.debugger.Intercept}, interceptors...)
+	}
+	if len(s.rewriteRules) > 0 {
+		rewriter, err := rewrite.NewRewriter(logger, s.decoder, s.rewriteRules)
+		if err != nil {
+			return nil, err
+		}
+		interceptors = append([]grpc.StreamServerInterceptor{rewriter.Intercept}, interceptors...)
+	}
+	if len(s.faultRules) > 0 {
+		// the injector is the outermost interceptor so the others handle RPCs as normal
+		s.connections = newConnections()
+		injector := fault.NewInjector(logger, s.faultRules, s.connections.close)
+		interceptors = append([]grpc.StreamServerInterceptor{injector.Intercept}, interceptors...)
+	}
+	if len(s.routes) > 0 {
+		interceptors = append([]grpc.StreamServerInterceptor{recordRoutes}, interceptors...)
+	}
+	if s.mirror != nil {
+		interceptors = append([]grpc.StreamServerInterceptor{recordMirrors}, interceptors...)
+	}
+	interceptors = append([]grpc.StreamServerInterceptor{s.cancelOnStop}, interceptors...)
+	s.serverOptions = append(s.serverOptions, grpc.StreamInterceptor(recoverWrapper(s, chainInterceptors(interceptors))))
+
+	if s.pcapngFile != "" {
+		var err error
+		s.captureFile, err = os.Create(s.pcapngFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create pcapng file")
+		}
+		s.capture, err = pcapng.NewWriter(s.captureFile)
+		if err != nil {
+			_ = s.captureFile.Close()
+			return nil, errors.Wrap(err, "failed to write pcapng file")
+		}
+		s.dialer = captureDialer(s.dialer, s.capture)
+	}
 
 	// Have to initialise the connpool now because
 	// the dialer may been changed by options
@@ -80,22 +182,34 @@ func New(configurators ...Configurator) (*server, error) {
 		logger.SetLevel(level)
 	}
 
-	if s.certFile == "" && s.keyFile == "" {
+	if len(s.keyPairs) == 0 {
 		var err error
-		s.certFile, s.keyFile, err = detectcert.Detect()
+		s.keyPairs, err = detectcert.Detect()
 		if err != nil {
 			s.logger.WithError(err).Info("Failed to detect certificates")
 		}
 	}
 
-	if s.certFile != "" && s.keyFile != "" {
-		var err error
-		s.tlsCert, err = tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	for _, keyPair := range s.keyPairs {
+		tlsCert, err := tls.LoadX509KeyPair(keyPair.CertFile, keyPair.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsCert.Leaf, err = x509.ParseCertificate(tlsCert.Certificate[0]) //TODO do we need to parse anything other than [0]?
 		if err != nil {
 			return nil, err
 		}
+		s.tlsCerts = append(s.tlsCerts, tlsCert)
+	}
+
+	if s.requestClientCerts {
+		s.connectionStates = tlsmux.NewConnectionStates()
+	}
 
-		s.x509Cert, err = x509.ParseCertificate(s.tlsCert.Certificate[0]) //TODO do we need to parse anything other than [0]?
+	if s.caCertFile != "" || s.caKeyFile != "" {
+		var err error
+		s.authority, err = ca.Load(s.caCertFile, s.caKeyFile)
 		if err != nil {
 			return nil, err
 		}
@@ -105,15 +219,27 @@ func New(configurators ...Configurator) (*server, error) {
 }
 
 func (s *server) Start() error {
+	if s.captureFile != nil {
+		// writes by connections which outlive Start fail (and are ignored) once the file is closed
+		defer s.captureFile.Close()
+	}
+
 	var err error
 	s.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.networkInterface, s.port))
 	if err != nil {
 		return fmt.Errorf("failed to listen on interface (%s:%d): %v", s.networkInterface, s.port, err)
 	}
 	s.logger.Infof("Listening on %s", s.listener.Addr())
-	if s.x509Cert != nil {
-		s.logger.Infof("Intercepting TLS connections to domains: %s", s.x509Cert.DNSNames)
-	} else {
+	switch {
+	case s.authority != nil:
+		s.logger.Infof("Intercepting all TLS connections using certificates signed by %s", s.authority.Subject())
+	case len(s.tlsCerts) > 0:
+		var domains []string
+		for _, tlsCert := range s.tlsCerts {
+			domains = append(domains, tlsCert.Leaf.DNSNames...)
+		}
+		s.logger.Infof("Intercepting TLS connections to domains: %s", domains)
+	default:
 		s.logger.Infof("Not intercepting TLS connections")
 	}
 
@@ -123,10 +249,14 @@ func (s *server) Start() error {
 		grpcweb.WithOriginFunc(func(_ string) bool { return true }),
 	)
 
-	proxyLis := newProxyListener(s.logger, s.listener)
+	var listener net.Listener = s.listener
+	if s.connections != nil {
+		listener = trackingListener{s.listener, s.connections}
+	}
+	proxyLis := newProxyListener(s.logger, listener)
 	httpReverseProxy := newReverseProxy(s.logger)
 	httpServer := newHttpServer(s.logger, grpcWebHandler, proxyLis.internalRedirect, httpReverseProxy)
-	httpsServer := withHttpsMiddleware(newHttpServer(s.logger, grpcWebHandler, proxyLis.internalRedirect, httpReverseProxy))
+	httpsServer := withHttpsMiddleware(newHttpServer(s.logger, grpcWebHandler, proxyLis.internalRedirect, httpReverseProxy), s.connectionStates)
 
 	// Use file path for Master Secrets file is specified. Send to /dev/null if not.
 	keyLogWriter := ioutil.Discard
@@ -136,20 +266,55 @@ func (s *server) Start() error {
 			return fmt.Errorf("failed opening secrets file on path: %s", s.tlsSecretsFile)
 		}
 	}
-	httpLis, httpsLis := tlsmux.New(s.logger, proxyLis, s.x509Cert, s.tlsCert, keyLogWriter)
+	httpLis, httpsLis := tlsmux.New(s.logger, proxyLis, s.tlsCerts, s.authority, s.connectionStates, keyLogWriter)
+	if s.capture != nil {
+		s.logger.Infof("Writing decrypted traffic to %s", s.pcapngFile)
+		httpLis = captureListener{httpLis, s.capture, "connection"}
+		httpsLis = captureListener{httpsLis, s.capture, "decrypted TLS connection"}
+	}
 
-	errChan := make(chan error)
+	// buffered so that the servers which are still running when Start returns don't block
+	errChan := make(chan error, 3)
+	if s.debugger != nil {
+		breakpointLis, err := net.Listen("tcp", s.breakpointAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for the breakpoint API (%s): %v", s.breakpointAddr, err)
+		}
+		defer breakpointLis.Close()
+		go func() {
+			errChan <- s.debugger.Serve(breakpointLis)
+		}()
+	}
+	disableProxy := func() error { return nil }
 	if s.enableSystemProxy {
-		disableProxy, err := proxy_settings.EnableProxy(s.listener.Addr().String())
+		disableProxy, err = proxy_settings.EnableProxy(s.listener.Addr().String())
 		if err != nil {
 			return errors.Wrap(err, "failed to enable system proxy")
 		}
 		s.logger.Info("Enabled system proxy.")
+	}
+
+	if s.stopOnInterrupt || s.enableSystemProxy {
+		// the system proxy must always be disabled again
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		go func() {
+			select {
+			case <-sigs:
+				s.logger.Info("Shutting down")
+				s.Stop()
+			case <-s.stopped:
+			}
+		}()
+	}
+	if s.stopWhenDone != nil {
 		go func() {
-			<-sigs
-			errChan <- disableProxy()
+			select {
+			case <-s.stopWhenDone.Done():
+				s.Stop()
+			case <-s.stopped:
+			}
 		}()
 	}
 
@@ -161,5 +326,57 @@ func (s *server) Start() error {
 		errChan <- httpsServer.Serve(httpsLis)
 	}()
 
-	return <-errChan
+	select {
+	case err = <-errChan:
+	case <-s.stopped:
+	}
+	// RPCs on connections which are still open are cancelled by cancelOnStop
+	s.Stop()
+	shutdownServers(httpServer, httpsServer)
+	_ = s.listener.Close()
+	if disableErr := disableProxy(); err == nil {
+		err = disableErr
+	}
+	return err
+}
+
+// shutdownServers stops the servers accepting connections and waits (up to shutdownTimeout)
+// for their connections to become idle before closing them
+func shutdownServers(servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				_ = srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
+
+// Stop makes Start return after it has stopped listening and cancelled the RPCs still in progress
+// (so that interceptors can finish handling them). It's safe to call more than once.
+func (s *server) Stop() {
+	s.stop.Do(func() {
+		close(s.stopped)
+	})
+}
+
+// cancelOnStop is the outermost interceptor: it cancels the context
+// of RPCs still in progress when the proxy is stopped
+func (s *server) cancelOnStop(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
 }
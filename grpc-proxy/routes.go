@@ -0,0 +1,62 @@
+package grpc_proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/routing"
+	"google.golang.org/grpc"
+)
+
+type routeKey struct{}
+
+// routeHolder is where the proxy handler records the route it forwarded an RPC to
+type routeHolder struct {
+	sync.Mutex
+	route *internal.Route
+}
+
+// recordRoutes is the outermost interceptor when routes are used so that
+// the route chosen by the proxy handler is available to all interceptors.
+func recordRoutes(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextServerStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), routeKey{}, &routeHolder{}),
+	})
+}
+
+// contextServerStream replaces the context of a stream (e.g. to add values to it)
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func setRoute(ctx context.Context, route *routing.Route, destination string) {
+	holder, ok := ctx.Value(routeKey{}).(*routeHolder)
+	if !ok {
+		return
+	}
+	holder.Lock()
+	defer holder.Unlock()
+	holder.route = &internal.Route{Destination: destination}
+	if route != nil {
+		holder.route.Name = route.Name
+	}
+}
+
+// ForwardedRoute returns the route an RPC was forwarded to by the proxy.
+// It's nil unless routes are used (see WithRoutes) and the RPC has been forwarded.
+func ForwardedRoute(ctx context.Context) *internal.Route {
+	holder, ok := ctx.Value(routeKey{}).(*routeHolder)
+	if !ok {
+		return nil
+	}
+	holder.Lock()
+	defer holder.Unlock()
+	return holder.route
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/bradleyjkemp/grpc-tools/internal/ca"
 	"github.com/bradleyjkemp/grpc-tools/testutils"
 
 	"golang.org/x/net/http2"
@@ -33,11 +34,11 @@ func TestTLSMux_HTTP2Support(t *testing.T) {
 	// New keypair.
 	tlsCert, err := testutils.NewSelfSignedKeyPair()
 	require.NoError(t, err, "failed loading X509 keypair")
-	x509Cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	tlsCert.Leaf, err = x509.ParseCertificate(tlsCert.Certificate[0])
 	require.NoError(t, err, "failed parsing certificate out of keypair")
 
 	// Get TLS listener.
-	_, httpsLis := tlsmux.New(logger, proxyLis, x509Cert, tlsCert, ioutil.Discard)
+	_, httpsLis := tlsmux.New(logger, proxyLis, []tls.Certificate{tlsCert}, nil, nil, ioutil.Discard)
 
 	// Start mock server with TLS listener.
 	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
@@ -55,3 +56,90 @@ func TestTLSMux_HTTP2Support(t *testing.T) {
 	_, err = client.Do(req)
 	require.NoError(t, err, "failed requesting")
 }
+
+// TestTLSMux_SelectsCertificateBySNI verifies that the certificate matching the requested server name is presented.
+func TestTLSMux_SelectsCertificateBySNI(t *testing.T) {
+	logger := logrus.New()
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err, "failed creating tcp listener")
+	proxyLis := newProxyListener(logger, ln)
+
+	// Use a CA to create certificates for two different domains.
+	caKeyPair, err := testutils.NewCAKeyPair()
+	require.NoError(t, err, "failed creating CA keypair")
+	authority, err := ca.New(caKeyPair)
+	require.NoError(t, err, "failed loading CA")
+	var tlsCerts []tls.Certificate
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		tlsCert, err := authority.CertificateFor(domain)
+		require.NoError(t, err, "failed creating certificate for %s", domain)
+		tlsCerts = append(tlsCerts, *tlsCert)
+	}
+
+	_, httpsLis := tlsmux.New(logger, proxyLis, tlsCerts, nil, nil, ioutil.Discard)
+	go func() {
+		for {
+			conn, err := httpsLis.Accept()
+			if err != nil {
+				return
+			}
+			go conn.(*tls.Conn).Handshake()
+		}
+	}()
+
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			ServerName:         domain,
+			InsecureSkipVerify: true,
+		})
+		require.NoError(t, err, "failed dialing with server name %s", domain)
+		require.Equal(t, []string{domain}, conn.ConnectionState().PeerCertificates[0].DNSNames)
+		conn.Close()
+	}
+}
+
+// TestTLSMux_RecordsClientCertificates verifies that client certificates are made available to HTTP handlers.
+func TestTLSMux_RecordsClientCertificates(t *testing.T) {
+	logger := logrus.New()
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err, "failed creating tcp listener")
+	proxyLis := newProxyListener(logger, ln)
+
+	serverCert, err := testutils.NewSelfSignedKeyPair()
+	require.NoError(t, err, "failed creating server keypair")
+	serverCert.Leaf, err = x509.ParseCertificate(serverCert.Certificate[0])
+	require.NoError(t, err, "failed parsing server certificate")
+	clientCert, err := testutils.NewSelfSignedKeyPair()
+	require.NoError(t, err, "failed creating client keypair")
+
+	states := tlsmux.NewConnectionStates()
+	_, httpsLis := tlsmux.New(logger, proxyLis, []tls.Certificate{serverCert}, nil, states, ioutil.Discard)
+
+	peerCerts := make(chan []*x509.Certificate, 1)
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = withHttpsMiddleware(&http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				peerCerts <- r.TLS.PeerCertificates
+			}
+			close(peerCerts)
+		}),
+	}, states)
+	server.Listener = httpsLis
+	server.Start()
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{clientCert},
+			},
+		},
+	}
+	_, err = client.Post("https://"+ln.Addr().String(), "", strings.NewReader(""))
+	require.NoError(t, err, "failed requesting")
+
+	certs := <-peerCerts
+	require.Len(t, certs, 1, "expected client certificate to be recorded")
+	require.Equal(t, clientCert.Certificate[0], certs[0].Raw)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
 	"github.com/bradleyjkemp/grpc-tools/grpc-replay/replay"
 	"github.com/bradleyjkemp/grpc-tools/internal/proxydialer"
 	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
@@ -18,8 +19,20 @@ func main() {
 		protoDescriptors    = flag.String("proto_descriptors", "", "A comma separated list of proto descriptors to load gRPC service definitions from.")
 	)
 
+	grpc_proxy.RegisterUpstreamTLSFlags()
 	flag.Parse()
-	err := replay.Run(*protoRoots, *protoDescriptors, *dumpPath, *destinationOverride, proxydialer.NewProxyDialer(httpproxy.FromEnvironment().ProxyFunc()))
+
+	upstreamTLSConfigs, err := grpc_proxy.UpstreamTLSFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	var opts []replay.Option
+	for destination, config := range upstreamTLSConfigs {
+		opts = append(opts, replay.WithUpstreamTLS(destination, config))
+	}
+
+	err = replay.Run(*protoRoots, *protoDescriptors, *dumpPath, *destinationOverride, proxydialer.NewProxyDialer(httpproxy.FromEnvironment().ProxyFunc()), opts...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		flag.Usage()
@@ -2,13 +2,14 @@ package replay
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
 	"github.com/bradleyjkemp/grpc-tools/internal"
 	"github.com/bradleyjkemp/grpc-tools/internal/codec"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpfile"
 	"github.com/bradleyjkemp/grpc-tools/internal/marker"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/upstreamtls"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -19,7 +20,34 @@ import (
 	"time"
 )
 
-func Run(protoRoots, protoDescriptors, dumpPath, destinationOverride string, dialer grpc_proxy.ContextDialer) error {
+type options struct {
+	upstreamTLS *upstreamtls.Configs
+	configErr   error
+}
+
+type Option func(*options)
+
+// WithUpstreamTLS sets the TLS config to use when connecting to servers matching
+// the destination pattern (see grpc_proxy.WithUpstreamTLS for the pattern format).
+func WithUpstreamTLS(destination string, config grpc_proxy.UpstreamTLSConfig) Option {
+	return func(o *options) {
+		if err := o.upstreamTLS.Add(destination, config); err != nil {
+			o.configErr = err
+		}
+	}
+}
+
+func Run(protoRoots, protoDescriptors, dumpPath, destinationOverride string, dialer grpc_proxy.ContextDialer, opts ...Option) error {
+	o := &options{
+		upstreamTLS: &upstreamtls.Configs{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.configErr != nil {
+		return o.configErr
+	}
+
 	pool := internal.NewConnPool(logrus.New(), dialer)
 
 	dumpFile, err := os.Open(dumpPath)
@@ -43,11 +71,10 @@ func Run(protoRoots, protoDescriptors, dumpPath, destinationOverride string, dia
 	}
 	encoder := proto_decoder.NewEncoder(resolvers...)
 
-	dumpDecoder := json.NewDecoder(dumpFile)
+	dumpReader := dumpfile.NewReader(dumpFile)
 RPC:
 	for {
-		rpc := internal.RPC{}
-		err := dumpDecoder.Decode(&rpc)
+		rpc, err := dumpReader.Next()
 		if err == io.EOF {
 			break
 		}
@@ -55,7 +82,7 @@ RPC:
 			return fmt.Errorf("failed to decode dump: %s", err)
 		}
 
-		conn, err := getConnection(pool, rpc.Metadata, destinationOverride)
+		conn, err := getConnection(pool, rpc.Metadata, destinationOverride, o.upstreamTLS)
 		if err != nil {
 			return fmt.Errorf("failed to connect to destination (%s): %s", destinationOverride, err)
 		}
@@ -108,7 +135,7 @@ RPC:
 	return nil
 }
 
-func getConnection(pool *internal.ConnPool, md metadata.MD, destinationOverride string) (*grpc.ClientConn, error) {
+func getConnection(pool *internal.ConnPool, md metadata.MD, destinationOverride string, upstreamTLS *upstreamtls.Configs) (*grpc.ClientConn, error) {
 	// if no destination override set then auto-detect from the metadata
 	var destination = destinationOverride
 	if destination == "" {
@@ -124,8 +151,9 @@ func getConnection(pool *internal.ConnPool, md metadata.MD, destinationOverride
 		grpc.WithBlock(),
 	}
 
-	if marker.IsTLSRPC(md) {
-		options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(nil)))
+	tlsConfig, useTLS := upstreamTLS.For(destination)
+	if marker.IsTLSRPC(md) || useTLS {
+		options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	} else {
 		options = append(options, grpc.WithInsecure())
 	}
@@ -0,0 +1,70 @@
+package breakpoint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal/methodmatch"
+)
+
+// Direction is which messages of an RPC a breakpoint holds
+type Direction string
+
+const (
+	Request  Direction = "request"
+	Response Direction = "response"
+)
+
+// Breakpoint holds the messages of RPCs matching Service and Method so that they can be edited,
+// dropped or released before they're forwarded
+type Breakpoint struct {
+	// Glob patterns matched against the fully qualified service name (e.g. payments.*)
+	// and the method name. Empty patterns match everything.
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+	// Only hold client (request) or server (response) messages. Empty holds both.
+	Direction Direction `json:"direction,omitempty"`
+}
+
+// Validate checks that the breakpoint's patterns and direction are valid
+func (b Breakpoint) Validate() error {
+	if err := methodmatch.ValidatePatterns(b.Service, b.Method); err != nil {
+		return err
+	}
+	switch b.Direction {
+	case "", Request, Response:
+		return nil
+	default:
+		return fmt.Errorf("invalid direction %q: must be %s or %s", b.Direction, Request, Response)
+	}
+}
+
+func (b Breakpoint) matches(fullMethod string, direction Direction) bool {
+	if b.Direction != "" && b.Direction != direction {
+		return false
+	}
+	return methodmatch.Method(b.Service, b.Method, fullMethod)
+}
+
+// Parse parses a comma separated list of breakpoints of the form
+// service/method[:direction] e.g. payments.*/Charge:request,*/Login
+func Parse(s string) ([]Breakpoint, error) {
+	var breakpoints []Breakpoint
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		var b Breakpoint
+		pattern := spec
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			pattern, b.Direction = spec[:i], Direction(spec[i+1:])
+		}
+		b.Service, b.Method = methodmatch.SplitMethod(pattern)
+		if err := b.Validate(); err != nil {
+			return nil, err
+		}
+		breakpoints = append(breakpoints, b)
+	}
+	return breakpoints, nil
+}
@@ -0,0 +1,115 @@
+package breakpoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestParse(t *testing.T) {
+	breakpoints, err := Parse("payments.*/Charge:request, */Login")
+	require.NoError(t, err)
+	require.Equal(t, []Breakpoint{
+		{Service: "payments.*", Method: "Charge", Direction: Request},
+		{Service: "*", Method: "Login"},
+	}, breakpoints)
+	require.True(t, breakpoints[0].matches("/payments.v1.Payments/Charge", Request))
+	require.False(t, breakpoints[0].matches("/payments.v1.Payments/Charge", Response))
+	require.True(t, breakpoints[1].matches("/auth.Auth/Login", Response))
+	require.False(t, breakpoints[1].matches("/auth.Auth/Logout", Response))
+
+	_, err = Parse("*/Login:sideways")
+	require.Error(t, err)
+	_, err = Parse("[/Login")
+	require.Error(t, err)
+}
+
+type decoder struct {
+	*dynamic.Message
+}
+
+func (d decoder) Decode(_ string, message *internal.Message) (*dynamic.Message, error) {
+	decoded := dynamic.NewMessage(d.GetMessageDescriptor())
+	return decoded, decoded.Unmarshal(message.RawMessage)
+}
+
+type mockServerStream struct {
+	grpc.ServerStream
+	received [][]byte
+}
+
+func (m *mockServerStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockServerStream) RecvMsg(msg interface{}) error {
+	*msg.(*[]byte) = m.received[0]
+	m.received = m.received[1:]
+	return nil
+}
+
+func TestDebugger(t *testing.T) {
+	file, err := builder.NewFile("test.proto").SetPackageName("test").
+		AddMessage(builder.NewMessage("Request").AddField(builder.NewField("name", builder.FieldTypeString()))).
+		Build()
+	require.NoError(t, err)
+	message := dynamic.NewMessage(file.FindMessage("test.Request"))
+	message.SetFieldByName("name", "first")
+	first, _ := message.Marshal()
+	message.SetFieldByName("name", "second")
+	second, _ := message.Marshal()
+
+	d := NewDebugger(logrus.New(), decoder{message}, []Breakpoint{{Service: "test.*", Direction: Request}})
+	api := httptest.NewServer(d)
+	defer api.Close()
+
+	received := make(chan []byte)
+	go d.Intercept(nil, &mockServerStream{received: [][]byte{first, second}}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Method"}, func(_ interface{}, ss grpc.ServerStream) error {
+		var m []byte
+		if err := ss.RecvMsg(&m); err != nil {
+			return err
+		}
+		received <- m
+		return nil
+	})
+
+	waitForHeld := func() *Held {
+		require.Eventually(t, func() bool { return len(d.Held()) == 1 }, time.Second, time.Millisecond)
+		var held []*Held
+		resp, err := http.Get(api.URL + "/messages")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&held))
+		require.Len(t, held, 1)
+		return held[0]
+	}
+
+	// the first message is dropped so the handler receives the (edited) second one
+	held := waitForHeld()
+	require.Equal(t, first, held.RawMessage)
+	resp, err := http.Post(api.URL+"/messages/1/drop", "", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	held = waitForHeld()
+	require.Equal(t, second, held.RawMessage)
+	resp, err = http.Post(api.URL+"/messages/2/release", "application/json", strings.NewReader(`{"name": "edited"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	edited := dynamic.NewMessage(message.GetMessageDescriptor())
+	require.NoError(t, edited.Unmarshal(<-received))
+	require.Equal(t, "edited", edited.GetFieldByName("name"))
+	require.Empty(t, d.Held())
+}
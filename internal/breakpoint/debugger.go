@@ -0,0 +1,287 @@
+package breakpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Debugger holds the messages of RPCs matching its breakpoints until they're
+// released (optionally edited) or dropped using its HTTP API
+type Debugger struct {
+	logger      logrus.FieldLogger
+	decoder     proto_decoder.MessageDecoder
+	breakpoints []Breakpoint
+
+	sync.Mutex
+	url    string
+	nextID int
+	held   map[int]*Held
+}
+
+// Held is a message waiting at a breakpoint
+type Held struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	*internal.Message
+
+	descriptor *desc.MessageDescriptor
+	decision   chan decision
+}
+
+type decision struct {
+	drop bool
+	raw  []byte
+}
+
+func NewDebugger(logger logrus.FieldLogger, decoder proto_decoder.MessageDecoder, breakpoints []Breakpoint) *Debugger {
+	return &Debugger{
+		logger:      logger,
+		decoder:     decoder,
+		breakpoints: breakpoints,
+		held:        map[int]*Held{},
+	}
+}
+
+// Intercept implements a grpc.StreamServerInterceptor
+func (d *Debugger) Intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	stream := &breakpointServerStream{ServerStream: ss, debugger: d, fullMethod: info.FullMethod}
+	for _, breakpoint := range d.breakpoints {
+		stream.holdRequests = stream.holdRequests || breakpoint.matches(info.FullMethod, Request)
+		stream.holdResponses = stream.holdResponses || breakpoint.matches(info.FullMethod, Response)
+	}
+	if !stream.holdRequests && !stream.holdResponses {
+		return handler(srv, ss)
+	}
+	return handler(srv, stream)
+}
+
+// hold waits for a message to be released or dropped (or for the RPC to be cancelled)
+func (d *Debugger) hold(ctx context.Context, fullMethod string, origin internal.MessageOrigin, raw []byte) ([]byte, bool, error) {
+	held := &Held{
+		Method: fullMethod,
+		Message: &internal.Message{
+			MessageOrigin: origin,
+			RawMessage:    raw,
+			Timestamp:     time.Now(),
+		},
+		decision: make(chan decision, 1),
+	}
+	decoded, err := d.decoder.Decode(fullMethod, held.Message)
+	if err != nil {
+		d.logger.WithError(err).Warn("Failed to decode held message")
+	} else {
+		held.Message.Message = decoded
+		held.descriptor = decoded.GetMessageDescriptor()
+	}
+
+	d.Lock()
+	d.nextID++
+	held.ID = d.nextID
+	d.held[held.ID] = held
+	url := d.url
+	d.Unlock()
+	defer d.remove(held.ID)
+
+	d.logger.WithField("method", fullMethod).WithField("origin", origin).
+		Warnf("Holding message %d at breakpoint: release it with `curl -X POST %s/messages/%d/release` (or drop it with /drop)", held.ID, url, held.ID)
+
+	select {
+	case decision := <-held.decision:
+		return decision.raw, decision.drop, nil
+	case <-ctx.Done():
+		return nil, false, status.Error(codes.Canceled, "RPC cancelled while held at a breakpoint")
+	}
+}
+
+func (d *Debugger) remove(id int) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.held, id)
+}
+
+// Held returns the messages waiting at breakpoints in the order they arrived
+func (d *Debugger) Held() []*Held {
+	d.Lock()
+	defer d.Unlock()
+	held := make([]*Held, 0, len(d.held))
+	for _, h := range d.held {
+		held = append(held, h)
+	}
+	sort.Slice(held, func(i, j int) bool {
+		return held[i].ID < held[j].ID
+	})
+	return held
+}
+
+// Release forwards a held message. If edited isn't empty, it's the JSON
+// (i.e. decoded form) of the message to forward instead.
+func (d *Debugger) Release(id int, edited []byte) error {
+	d.Lock()
+	held, ok := d.held[id]
+	d.Unlock()
+	if !ok {
+		return fmt.Errorf("no message %d is held", id)
+	}
+	raw := held.RawMessage
+	if len(edited) > 0 {
+		var err error
+		raw, err = encode(held, edited)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := d.take(id); err != nil {
+		return err
+	}
+	held.decision <- decision{raw: raw}
+	return nil
+}
+
+// Drop discards a held message instead of forwarding it
+func (d *Debugger) Drop(id int) error {
+	held, err := d.take(id)
+	if err != nil {
+		return err
+	}
+	held.decision <- decision{drop: true}
+	return nil
+}
+
+// take removes a held message so that only one decision is made about it
+func (d *Debugger) take(id int) (*Held, error) {
+	d.Lock()
+	defer d.Unlock()
+	held, ok := d.held[id]
+	if !ok {
+		return nil, fmt.Errorf("no message %d is held", id)
+	}
+	delete(d.held, id)
+	return held, nil
+}
+
+func encode(held *Held, edited []byte) ([]byte, error) {
+	if held.descriptor == nil {
+		return nil, fmt.Errorf("message %d couldn't be decoded so can't be edited", held.ID)
+	}
+	message := dynamic.NewMessage(held.descriptor)
+	if err := message.UnmarshalJSON(edited); err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+	return message.Marshal()
+}
+
+// Serve serves the HTTP API for listing (GET /messages), releasing
+// (POST /messages/{id}/release with the edited message as the optional body)
+// and dropping (POST /messages/{id}/drop) held messages
+func (d *Debugger) Serve(listener net.Listener) error {
+	d.Lock()
+	d.url = "http://" + listener.Addr().String()
+	d.Unlock()
+	d.logger.Infof("Breakpoint API listening on %s", d.url)
+	return http.Serve(listener, d)
+}
+
+func (d *Debugger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "messages" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d.Held()); err != nil {
+			d.logger.WithError(err).Warn("Failed to write held messages")
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch parts[2] {
+	case "release":
+		var body []byte
+		body, err = ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = d.Release(id, body)
+		}
+	case "drop":
+		err = d.Drop(id)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+type breakpointServerStream struct {
+	grpc.ServerStream
+	debugger      *Debugger
+	fullMethod    string
+	holdRequests  bool
+	holdResponses bool
+}
+
+func (s *breakpointServerStream) RecvMsg(m interface{}) error {
+	for {
+		if err := s.ServerStream.RecvMsg(m); err != nil {
+			return err
+		}
+		raw, ok := m.(*[]byte)
+		if !ok || !s.holdRequests {
+			return nil
+		}
+		released, drop, err := s.debugger.hold(s.Context(), s.fullMethod, internal.ClientMessage, *raw)
+		if err != nil {
+			return err
+		}
+		if !drop {
+			*raw = released
+			return nil
+		}
+		// a dropped client message is never forwarded so wait for the next one
+	}
+}
+
+func (s *breakpointServerStream) SendMsg(m interface{}) error {
+	raw, ok := m.([]byte)
+	if !ok || !s.holdResponses {
+		return s.ServerStream.SendMsg(m)
+	}
+	released, drop, err := s.debugger.hold(s.Context(), s.fullMethod, internal.ServerMessage, raw)
+	if err != nil || drop {
+		return err
+	}
+	return s.ServerStream.SendMsg(released)
+}
@@ -0,0 +1,132 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// An Authority signs leaf certificates for arbitrary hostnames on demand
+// so that TLS connections to any destination can be intercepted.
+// Clients must be configured to trust the CA certificate (e.g. via `mkcert -install`).
+type Authority struct {
+	cert   *x509.Certificate
+	signer crypto.Signer
+
+	// all leaf certificates share a single key as generating keys is (relatively) slow
+	leafKey *ecdsa.PrivateKey
+
+	sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// Load reads a PEM encoded CA certificate and private key
+// (e.g. the rootCA.pem and rootCA-key.pem files created by mkcert).
+func Load(certFile, keyFile string) (*Authority, error) {
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load CA key pair")
+	}
+	return New(keyPair)
+}
+
+func New(keyPair tls.Certificate) (*Authority, error) {
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse CA certificate")
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA certificate", cert.Subject)
+	}
+	signer, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA private key type %T", keyPair.PrivateKey)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate leaf key")
+	}
+
+	return &Authority{
+		cert:    cert,
+		signer:  signer,
+		leafKey: leafKey,
+		leaves:  map[string]*tls.Certificate{},
+	}, nil
+}
+
+// Subject returns the name of the CA certificate used to sign leaf certificates
+func (a *Authority) Subject() string {
+	return a.cert.Subject.String()
+}
+
+// CertificateFor returns a certificate valid for the hostname,
+// signing a new one if none has been created yet.
+func (a *Authority) CertificateFor(hostname string) (*tls.Certificate, error) {
+	a.Lock()
+	defer a.Unlock()
+	if leaf, ok := a.leaves[hostname]; ok && time.Now().Before(leaf.Leaf.NotAfter) {
+		return leaf, nil
+	}
+
+	leaf, err := a.sign(hostname)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sign certificate for %s", hostname)
+	}
+	a.leaves[hostname] = leaf
+	return leaf, nil
+}
+
+func (a *Authority) sign(hostname string) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := time.Now().AddDate(1, 0, 0)
+	if notAfter.After(a.cert.NotAfter) {
+		notAfter = a.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"grpc-tools generated certificate"},
+			CommonName:   hostname,
+		},
+		NotBefore:   time.Now().Add(-time.Hour), // allow for some clock skew
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(hostname); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{hostname}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, a.leafKey.Public(), a.signer)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, a.cert.Raw},
+		PrivateKey:  a.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
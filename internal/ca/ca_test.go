@@ -0,0 +1,42 @@
+package ca
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthority_CertificateFor(t *testing.T) {
+	caKeyPair, err := testutils.NewCAKeyPair()
+	require.NoError(t, err)
+	authority, err := New(caKeyPair)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	caCert, err := x509.ParseCertificate(caKeyPair.Certificate[0])
+	require.NoError(t, err)
+	roots.AddCert(caCert)
+
+	for _, hostname := range []string{"example.com", "127.0.0.1"} {
+		leaf, err := authority.CertificateFor(hostname)
+		require.NoError(t, err)
+		_, err = leaf.Leaf.Verify(x509.VerifyOptions{
+			DNSName: hostname,
+			Roots:   roots,
+		})
+		require.NoError(t, err, "leaf certificate for %s not valid", hostname)
+
+		cached, err := authority.CertificateFor(hostname)
+		require.NoError(t, err)
+		require.True(t, leaf == cached, "expected certificate for %s to be cached", hostname)
+	}
+}
+
+func TestNew_RejectsNonCA(t *testing.T) {
+	keyPair, err := testutils.NewSelfSignedKeyPair()
+	require.NoError(t, err)
+	_, err = New(keyPair)
+	require.Error(t, err)
+}
@@ -40,7 +40,14 @@ func (c *ConnPool) addConn(destination string, conn *grpc.ClientConn) {
 }
 
 func (c *ConnPool) GetClientConn(ctx context.Context, destination string, dialOptions ...grpc.DialOption) (*grpc.ClientConn, error) {
-	conn, ok := c.getConn(destination)
+	return c.GetKeyedClientConn(ctx, destination, destination, dialOptions...)
+}
+
+// GetKeyedClientConn is like GetClientConn but caches the connection under key
+// instead of the destination. This allows for multiple differently
+// configured connections to the same destination.
+func (c *ConnPool) GetKeyedClientConn(ctx context.Context, key, destination string, dialOptions ...grpc.DialOption) (*grpc.ClientConn, error) {
+	conn, ok := c.getConn(key)
 	if ok {
 		c.logger.Debugf("Returning cached connection to %s", destination)
 		return conn, nil
@@ -54,6 +61,6 @@ func (c *ConnPool) GetClientConn(ctx context.Context, destination string, dialOp
 		return nil, fmt.Errorf("failed dialing %s: %v", destination, err)
 	}
 
-	c.addConn(destination, conn)
+	c.addConn(key, conn)
 	return conn, nil
 }
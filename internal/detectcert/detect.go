@@ -7,22 +7,28 @@ import (
 )
 
 var (
-	keySuffix = "-key.pem"
+	keySuffix  = "-key.pem"
 	certSuffix = ".pem"
 )
 
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
 // Detect finds files in the current directory that look like
 // mkcert-generated key-certificate pairs
-func Detect() (cert string, key string, err error) {
+func Detect() ([]KeyPair, error) {
 	wd, err := os.Getwd()
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	files, err := ioutil.ReadDir(wd)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
+	var pairs []KeyPair
 	for _, file := range files {
 		if file.IsDir() {
 			continue
@@ -34,10 +40,13 @@ func Detect() (cert string, key string, err error) {
 			_, err := os.Stat(certName)
 			if err == nil {
 				// found a key and a cert that match the mkcert pattern
-				return certName, keyName, nil
+				pairs = append(pairs, KeyPair{
+					CertFile: certName,
+					KeyFile:  keyName,
+				})
 			}
 		}
 	}
 
-	return "", "", nil
+	return pairs, nil
 }
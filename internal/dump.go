@@ -1,20 +1,28 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"time"
 
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type RPC struct {
-	Service              string      `json:"service"`
-	Method               string      `json:"method"`
-	Messages             []*Message  `json:"messages"`
-	Status               *Status     `json:"error,omitempty"`
-	Metadata             metadata.MD `json:"metadata"`
-	MetadataRespHeaders  metadata.MD `json:"metadata_response_headers"`
-	MetadataRespTrailers metadata.MD `json:"metadata_response_trailers"`
+	Service              string       `json:"service"`
+	Method               string       `json:"method"`
+	Messages             []*Message   `json:"messages"`
+	Status               *Status      `json:"error,omitempty"`
+	Metadata             metadata.MD  `json:"metadata"`
+	MetadataRespHeaders  metadata.MD  `json:"metadata_response_headers"`
+	MetadataRespTrailers metadata.MD  `json:"metadata_response_trailers"`
+	ClientCertificate    *Certificate `json:"client_certificate,omitempty"`
+	Route                *Route       `json:"route,omitempty"`
+	Mirror               *Mirror      `json:"mirror,omitempty"`
 }
 
 type Status struct {
@@ -22,6 +30,49 @@ type Status struct {
 	Message string `json:"message"`
 }
 
+// Err converts a recorded status back into a gRPC error
+func (s *Status) Err() error {
+	if s == nil {
+		return nil
+	}
+	return status.Error(parseCode(s.Code), s.Message)
+}
+
+// parseCode is the inverse of codes.Code.String()
+func parseCode(name string) codes.Code {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == name {
+			return c
+		}
+	}
+	var c uint32
+	if _, err := fmt.Sscanf(name, "Code(%d)", &c); err == nil {
+		return codes.Code(c)
+	}
+	return codes.Unknown
+}
+
+// Route is the upstream server an RPC was forwarded to when routing rules are used
+type Route struct {
+	// Name of the matching route (empty if no route matched)
+	Name        string `json:"name,omitempty"`
+	Destination string `json:"destination"`
+}
+
+// Certificate identifies the certificate presented by a client
+type Certificate struct {
+	Subject     string `json:"subject"`
+	Fingerprint string `json:"sha256_fingerprint"`
+}
+
+func NewCertificate(cert *x509.Certificate) *Certificate {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return &Certificate{
+		Subject:     cert.Subject.String(),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+}
+
 func (r RPC) StreamName() string {
 	return fmt.Sprintf("/%s/%s", r.Service, r.Method)
 }
@@ -38,4 +89,46 @@ type Message struct {
 	RawMessage    []byte        `json:"raw_message"`
 	Message       interface{}   `json:"message,omitempty"`
 	Timestamp     time.Time     `json:"timestamp"`
+	// Template is set on hand-written server messages whose string values are
+	// templates rendered by grpc-fixture using the request (see the grpc-fixture README)
+	Template bool `json:"template,omitempty"`
+}
+
+type EventType string
+
+const (
+	StreamStart   EventType = "start"
+	StreamMessage EventType = "message"
+	StreamHeaders EventType = "headers"
+	StreamTrailer EventType = "trailers"
+	StreamEnd     EventType = "end"
+	// written after the end event once the mirror (if any) has responded
+	StreamMirror EventType = "mirror"
+)
+
+// StreamEvent is a single event in the life of an RPC. Events for
+// the same RPC share a StreamID and can be reassembled into an RPC.
+type StreamEvent struct {
+	StreamID  string    `json:"stream_id"`
+	Event     EventType `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// set for start events
+	Service           string       `json:"service,omitempty"`
+	Method            string       `json:"method,omitempty"`
+	ClientCertificate *Certificate `json:"client_certificate,omitempty"`
+
+	// set for start, headers and trailers events
+	Metadata metadata.MD `json:"metadata,omitempty"`
+
+	// set for message events
+	Message *Message `json:"message,omitempty"`
+
+	// set for end events if the gRPC status is not OK
+	Status *Status `json:"error,omitempty"`
+	// set for end events when routing rules are used
+	Route *Route `json:"route,omitempty"`
+
+	// set for mirror events
+	Mirror *Mirror `json:"mirror,omitempty"`
 }
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusErr(t *testing.T) {
+	var ok *Status
+	require.NoError(t, ok.Err())
+
+	for _, code := range []codes.Code{codes.NotFound, codes.Unauthenticated, codes.Code(42)} {
+		err := (&Status{Code: code.String(), Message: "foo"}).Err()
+		require.Equal(t, code, status.Code(err))
+		require.Equal(t, "foo", status.Convert(err).Message())
+	}
+}
+
+func TestMirrorDifferences(t *testing.T) {
+	rpc := &RPC{
+		Messages: []*Message{
+			{MessageOrigin: ClientMessage, RawMessage: []byte{1}},
+			{MessageOrigin: ServerMessage, RawMessage: []byte{2}},
+		},
+	}
+	require.Empty(t, MirrorDifferences(rpc, &Mirror{
+		Messages: []*Message{{MessageOrigin: ServerMessage, RawMessage: []byte{2}}},
+	}))
+
+	require.Equal(t, []string{"status OK != Unavailable", "1 server messages != 0"}, MirrorDifferences(rpc, &Mirror{
+		Status: &Status{Code: "Unavailable"},
+	}))
+	require.Equal(t, []string{"server message 0 differs"}, MirrorDifferences(rpc, &Mirror{
+		Messages: []*Message{{MessageOrigin: ServerMessage, RawMessage: []byte{3}}},
+	}))
+	// mirrors which weren't sent all the client messages aren't compared
+	require.Empty(t, MirrorDifferences(rpc, &Mirror{
+		Status:     &Status{Code: "Unavailable"},
+		Incomplete: true,
+	}))
+}
@@ -0,0 +1,435 @@
+package dumpfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The binary dump format is a header followed by a sequence of RPCs.
+// Each RPC is prefixed by its length as a varint and encoded as a protobuf message:
+//
+//	message RPC {
+//	  string service = 1;
+//	  string method = 2;
+//	  repeated Message messages = 3;
+//	  Status status = 4;
+//	  repeated Metadata metadata = 5;
+//	  repeated Metadata response_headers = 6;
+//	  repeated Metadata response_trailers = 7;
+//	  Certificate client_certificate = 8;
+//	}
+//	message Message {
+//	  int32 origin = 1; // 1 = client, 2 = server
+//	  bytes raw_message = 2;
+//	  int64 timestamp = 3; // nanoseconds since the unix epoch
+//	}
+//	message Status {
+//	  string code = 1;
+//	  string message = 2;
+//	}
+//	message Metadata {
+//	  string key = 1;
+//	  repeated string values = 2;
+//	}
+//	message Certificate {
+//	  string subject = 1;
+//	  string sha256_fingerprint = 2;
+//	}
+//
+// Decoded messages are not stored: they can be decoded again from the raw messages.
+const binaryHeader = "GRPCDUMP\x00\x01"
+
+const maxRecordSize = 1 << 30
+
+// BinaryWriter writes RPCs in the binary dump format
+type BinaryWriter struct {
+	w      io.Writer
+	index  *IndexWriter
+	offset int64
+}
+
+// NewBinaryWriter writes the binary dump header to w.
+// If index is not nil, the position of each RPC is recorded in it.
+func NewBinaryWriter(w io.Writer, index *IndexWriter) (*BinaryWriter, error) {
+	n, err := io.WriteString(w, binaryHeader)
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryWriter{
+		w:      w,
+		index:  index,
+		offset: int64(n),
+	}, nil
+}
+
+// NewBinaryAppender writes RPCs to the end of an existing binary dump so doesn't write the header
+func NewBinaryAppender(w io.Writer) *BinaryWriter {
+	return &BinaryWriter{
+		w: w,
+	}
+}
+
+func (b *BinaryWriter) Write(rpc *internal.RPC) error {
+	record := encodeRPC(rpc)
+	buf := protowire.AppendVarint(make([]byte, 0, binary.MaxVarintLen64+len(record)), uint64(len(record)))
+	buf = append(buf, record...)
+	n, err := b.w.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	if b.index != nil {
+		err = b.index.write(IndexEntry{
+			FullMethod: rpc.StreamName(),
+			Timestamp:  StartTime(rpc),
+			Offset:     b.offset,
+			Length:     int64(n),
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to write index")
+		}
+	}
+	b.offset += int64(n)
+	return nil
+}
+
+// StartTime is the timestamp of the first message in an RPC
+func StartTime(rpc *internal.RPC) time.Time {
+	for _, message := range rpc.Messages {
+		if !message.Timestamp.IsZero() {
+			return message.Timestamp
+		}
+	}
+	return time.Time{}
+}
+
+// IsBinary returns whether r is a binary dump without consuming any of it
+func IsBinary(r *bufio.Reader) bool {
+	header, _ := r.Peek(len(binaryHeader))
+	return string(header) == binaryHeader
+}
+
+type binaryReader struct {
+	r *bufio.Reader
+}
+
+func (b *binaryReader) next() (*internal.RPC, error) {
+	length, err := binary.ReadUvarint(b.r)
+	if err != nil {
+		// EOF between records is the expected end of the dump
+		return nil, err
+	}
+	return readRecord(b.r, length)
+}
+
+// ReadAt reads the RPC at a position given by an index entry
+func ReadAt(r io.ReaderAt, entry IndexEntry) (*internal.RPC, error) {
+	record := bufio.NewReader(io.NewSectionReader(r, entry.Offset, entry.Length))
+	length, err := binary.ReadUvarint(record)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read RPC at offset %d", entry.Offset)
+	}
+	return readRecord(record, length)
+}
+
+func readRecord(r io.Reader, length uint64) (*internal.RPC, error) {
+	if length > maxRecordSize {
+		return nil, fmt.Errorf("RPC record of %d bytes is too large", length)
+	}
+	record := make([]byte, length)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, errors.Wrap(err, "truncated RPC record")
+	}
+	return decodeRPC(record)
+}
+
+func encodeRPC(rpc *internal.RPC) []byte {
+	var b []byte
+	b = appendString(b, 1, rpc.Service)
+	b = appendString(b, 2, rpc.Method)
+	for _, message := range rpc.Messages {
+		b = appendBytes(b, 3, encodeMessage(message))
+	}
+	if rpc.Status != nil {
+		b = appendBytes(b, 4, encodeStatus(rpc.Status))
+	}
+	b = appendMetadata(b, 5, rpc.Metadata)
+	b = appendMetadata(b, 6, rpc.MetadataRespHeaders)
+	b = appendMetadata(b, 7, rpc.MetadataRespTrailers)
+	if rpc.ClientCertificate != nil {
+		var c []byte
+		c = appendString(c, 1, rpc.ClientCertificate.Subject)
+		c = appendString(c, 2, rpc.ClientCertificate.Fingerprint)
+		b = appendBytes(b, 8, c)
+	}
+	if rpc.Route != nil {
+		var r []byte
+		r = appendString(r, 1, rpc.Route.Name)
+		r = appendString(r, 2, rpc.Route.Destination)
+		b = appendBytes(b, 9, r)
+	}
+	if rpc.Mirror != nil {
+		var m []byte
+		m = appendString(m, 1, rpc.Mirror.Destination)
+		for _, message := range rpc.Mirror.Messages {
+			m = appendBytes(m, 2, encodeMessage(message))
+		}
+		if rpc.Mirror.Status != nil {
+			m = appendBytes(m, 3, encodeStatus(rpc.Mirror.Status))
+		}
+		m = appendMetadata(m, 4, rpc.Mirror.MetadataRespHeaders)
+		m = appendMetadata(m, 5, rpc.Mirror.MetadataRespTrailers)
+		for _, difference := range rpc.Mirror.Differences {
+			m = appendString(m, 6, difference)
+		}
+		if rpc.Mirror.Incomplete {
+			m = appendVarint(m, 7, 1)
+		}
+		b = appendBytes(b, 10, m)
+	}
+	return b
+}
+
+func encodeMessage(message *internal.Message) []byte {
+	var m []byte
+	switch message.MessageOrigin {
+	case internal.ClientMessage:
+		m = appendVarint(m, 1, 1)
+	case internal.ServerMessage:
+		m = appendVarint(m, 1, 2)
+	}
+	m = appendBytes(m, 2, message.RawMessage)
+	if !message.Timestamp.IsZero() {
+		m = appendVarint(m, 3, uint64(message.Timestamp.UnixNano()))
+	}
+	return m
+}
+
+func encodeStatus(status *internal.Status) []byte {
+	var s []byte
+	s = appendString(s, 1, status.Code)
+	s = appendString(s, 2, status.Message)
+	return s
+}
+
+func decodeRPC(b []byte) (*internal.RPC, error) {
+	rpc := &internal.RPC{
+		Messages: []*internal.Message{},
+	}
+	err := decodeFields(b, func(num protowire.Number, v uint64, bytes []byte) error {
+		switch num {
+		case 1:
+			rpc.Service = string(bytes)
+		case 2:
+			rpc.Method = string(bytes)
+		case 3:
+			message, err := decodeMessage(bytes)
+			if err != nil {
+				return err
+			}
+			rpc.Messages = append(rpc.Messages, message)
+		case 4:
+			rpc.Status = &internal.Status{}
+			return decodeStatus(bytes, rpc.Status)
+		case 5:
+			return decodeMetadata(bytes, &rpc.Metadata)
+		case 6:
+			return decodeMetadata(bytes, &rpc.MetadataRespHeaders)
+		case 7:
+			return decodeMetadata(bytes, &rpc.MetadataRespTrailers)
+		case 8:
+			rpc.ClientCertificate = &internal.Certificate{}
+			return decodeFields(bytes, func(num protowire.Number, _ uint64, bytes []byte) error {
+				switch num {
+				case 1:
+					rpc.ClientCertificate.Subject = string(bytes)
+				case 2:
+					rpc.ClientCertificate.Fingerprint = string(bytes)
+				}
+				return nil
+			})
+		case 9:
+			rpc.Route = &internal.Route{}
+			return decodeFields(bytes, func(num protowire.Number, _ uint64, bytes []byte) error {
+				switch num {
+				case 1:
+					rpc.Route.Name = string(bytes)
+				case 2:
+					rpc.Route.Destination = string(bytes)
+				}
+				return nil
+			})
+		case 10:
+			rpc.Mirror = &internal.Mirror{Messages: []*internal.Message{}}
+			return decodeMirror(bytes, rpc.Mirror)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed RPC record")
+	}
+	return rpc, nil
+}
+
+func decodeStatus(b []byte, status *internal.Status) error {
+	return decodeFields(b, func(num protowire.Number, _ uint64, bytes []byte) error {
+		switch num {
+		case 1:
+			status.Code = string(bytes)
+		case 2:
+			status.Message = string(bytes)
+		}
+		return nil
+	})
+}
+
+func decodeMirror(b []byte, mirror *internal.Mirror) error {
+	return decodeFields(b, func(num protowire.Number, v uint64, bytes []byte) error {
+		switch num {
+		case 1:
+			mirror.Destination = string(bytes)
+		case 2:
+			message, err := decodeMessage(bytes)
+			if err != nil {
+				return err
+			}
+			mirror.Messages = append(mirror.Messages, message)
+		case 3:
+			mirror.Status = &internal.Status{}
+			return decodeStatus(bytes, mirror.Status)
+		case 4:
+			return decodeMetadata(bytes, &mirror.MetadataRespHeaders)
+		case 5:
+			return decodeMetadata(bytes, &mirror.MetadataRespTrailers)
+		case 6:
+			mirror.Differences = append(mirror.Differences, string(bytes))
+		case 7:
+			mirror.Incomplete = v != 0
+		}
+		return nil
+	})
+}
+
+func decodeMessage(b []byte) (*internal.Message, error) {
+	message := &internal.Message{}
+	err := decodeFields(b, func(num protowire.Number, v uint64, bytes []byte) error {
+		switch num {
+		case 1:
+			switch v {
+			case 1:
+				message.MessageOrigin = internal.ClientMessage
+			case 2:
+				message.MessageOrigin = internal.ServerMessage
+			}
+		case 2:
+			message.RawMessage = append([]byte{}, bytes...)
+		case 3:
+			message.Timestamp = time.Unix(0, int64(v))
+		}
+		return nil
+	})
+	return message, err
+}
+
+func decodeMetadata(b []byte, md *metadata.MD) error {
+	var key string
+	var values []string
+	err := decodeFields(b, func(num protowire.Number, _ uint64, bytes []byte) error {
+		switch num {
+		case 1:
+			key = string(bytes)
+		case 2:
+			values = append(values, string(bytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *md == nil {
+		*md = metadata.MD{}
+	}
+	(*md)[key] = append((*md)[key], values...)
+	return nil
+}
+
+// decodeFields calls f with the value of each varint and length delimited field
+func decodeFields(b []byte, f func(num protowire.Number, v uint64, bytes []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v uint64
+		var bytes []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(b)
+		default:
+			// skip fields added by future versions
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := f(num, v, bytes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, bytes []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, bytes)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendMetadata(b []byte, num protowire.Number, md metadata.MD) []byte {
+	// sort keys so that the output is deterministic
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var m []byte
+		m = appendString(m, 1, key)
+		for _, value := range md[key] {
+			m = protowire.AppendTag(m, 2, protowire.BytesType)
+			m = protowire.AppendString(m, value)
+		}
+		b = appendBytes(b, num, m)
+	}
+	return b
+}
@@ -0,0 +1,121 @@
+package dumpfile
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestBinary_RoundTrip(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	rpcs := []*internal.RPC{
+		{
+			Service: "svc",
+			Method:  "Unary",
+			Messages: []*internal.Message{
+				{MessageOrigin: internal.ClientMessage, RawMessage: []byte{1, 2}, Timestamp: start},
+				{MessageOrigin: internal.ServerMessage, RawMessage: []byte{}, Timestamp: start.Add(time.Second)},
+			},
+			Metadata:             metadata.MD{":authority": []string{"example.com"}, "empty": []string{""}},
+			MetadataRespHeaders:  metadata.MD{"content-type": []string{"application/grpc"}},
+			MetadataRespTrailers: metadata.MD{"trailer": []string{"a", "b"}},
+			ClientCertificate:    &internal.Certificate{Subject: "CN=client", Fingerprint: "abcd"},
+			Route:                &internal.Route{Name: "dev", Destination: "localhost:9000"},
+			Mirror: &internal.Mirror{
+				Destination: "localhost:9001",
+				Messages: []*internal.Message{
+					{MessageOrigin: internal.ServerMessage, RawMessage: []byte{4, 5}, Timestamp: time.Unix(0, 3)},
+				},
+				Status:               &internal.Status{Code: "Unavailable", Message: "mirror down"},
+				MetadataRespHeaders:  metadata.MD{"content-type": []string{"application/grpc"}},
+				MetadataRespTrailers: metadata.MD{"trailer": []string{"c"}},
+				Differences:          []string{"status NotFound != Unavailable", "server message 0 differs"},
+				Incomplete:           true,
+			},
+		},
+		{
+			Service:  "svc",
+			Method:   "Failed",
+			Messages: []*internal.Message{},
+			Status:   &internal.Status{Code: "NotFound", Message: "no such thing"},
+		},
+	}
+
+	dump := &bytes.Buffer{}
+	index := &bytes.Buffer{}
+	w, err := NewBinaryWriter(dump, NewIndexWriter(index))
+	require.NoError(t, err)
+	for _, rpc := range rpcs {
+		require.NoError(t, w.Write(rpc))
+	}
+
+	read, err := ReadAll(bytes.NewReader(dump.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, read, 2)
+	for i := range rpcs {
+		requireRPCsEqual(t, rpcs[i], read[i])
+	}
+
+	// the index can be used to read individual RPCs
+	entries, err := ReadIndex(index)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "/svc/Unary", entries[0].FullMethod)
+	require.True(t, start.Equal(entries[0].Timestamp))
+
+	selected, err := entries.Select(Selector{Method: "/svc/F*"})
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	rpc, err := ReadAt(bytes.NewReader(dump.Bytes()), selected[0])
+	require.NoError(t, err)
+	requireRPCsEqual(t, rpcs[1], rpc)
+
+	selected, err = entries.Select(Selector{From: start.Add(time.Minute)})
+	require.NoError(t, err)
+	require.Empty(t, selected)
+}
+
+func TestBinary_Truncated(t *testing.T) {
+	dump := &bytes.Buffer{}
+	w, err := NewBinaryWriter(dump, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&internal.RPC{Service: "svc", Method: "Unary"}))
+
+	reader := NewReader(bytes.NewReader(dump.Bytes()[:dump.Len()-1]))
+	_, err = reader.Next()
+	require.Error(t, err)
+	require.NotEqual(t, io.EOF, err)
+}
+
+func requireRPCsEqual(t *testing.T, expected, actual *internal.RPC) {
+	t.Helper()
+	require.Len(t, actual.Messages, len(expected.Messages))
+	for i := range expected.Messages {
+		require.Equal(t, expected.Messages[i].MessageOrigin, actual.Messages[i].MessageOrigin)
+		require.Equal(t, expected.Messages[i].RawMessage, actual.Messages[i].RawMessage)
+		require.True(t, expected.Messages[i].Timestamp.Equal(actual.Messages[i].Timestamp))
+	}
+	expectedCopy, actualCopy := *expected, *actual
+	expectedCopy.Messages, actualCopy.Messages = nil, nil
+	require.Equal(t, expectedCopy, actualCopy)
+}
+
+func TestBinary_Append(t *testing.T) {
+	dump := &bytes.Buffer{}
+	w, err := NewBinaryWriter(dump, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&internal.RPC{Service: "svc", Method: "First", Messages: []*internal.Message{}}))
+
+	// appending doesn't write the header again
+	require.NoError(t, NewBinaryAppender(dump).Write(&internal.RPC{Service: "svc", Method: "Second", Messages: []*internal.Message{}}))
+
+	read, err := ReadAll(bytes.NewReader(dump.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, read, 2)
+	require.Equal(t, "Second", read[1].Method)
+}
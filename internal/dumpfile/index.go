@@ -0,0 +1,88 @@
+package dumpfile
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"time"
+)
+
+// IndexEntry records where an RPC is in a binary dump so that it can be read without reading the whole dump
+type IndexEntry struct {
+	FullMethod string    `json:"method"`
+	Timestamp  time.Time `json:"timestamp"`
+	Offset     int64     `json:"offset"`
+	Length     int64     `json:"length"`
+}
+
+// IndexWriter writes index entries as newline separated JSON
+type IndexWriter struct {
+	encoder *json.Encoder
+}
+
+func NewIndexWriter(w io.Writer) *IndexWriter {
+	return &IndexWriter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (i *IndexWriter) write(entry IndexEntry) error {
+	return i.encoder.Encode(entry)
+}
+
+type Index []IndexEntry
+
+func ReadIndex(r io.Reader) (Index, error) {
+	decoder := json.NewDecoder(r)
+	var index Index
+	for {
+		var entry IndexEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		index = append(index, entry)
+	}
+}
+
+// Selector selects RPCs by method and start time
+type Selector struct {
+	// glob pattern matched against the full method name (all methods if empty)
+	Method string
+	// time range the RPC must have started in (unbounded if zero)
+	From, To time.Time
+}
+
+func (s Selector) Matches(fullMethod string, timestamp time.Time) (bool, error) {
+	if s.Method != "" {
+		matched, err := path.Match(s.Method, fullMethod)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	if !s.From.IsZero() && timestamp.Before(s.From) {
+		return false, nil
+	}
+	if !s.To.IsZero() && timestamp.After(s.To) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Select returns the entries for the RPCs matching the selector
+func (i Index) Select(s Selector) (Index, error) {
+	var selected Index
+	for _, entry := range i {
+		matched, err := s.Matches(entry.FullMethod, entry.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			selected = append(selected, entry)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,181 @@
+package dumpfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/metadata"
+)
+
+// Reader reads RPCs from a grpc-dump output stream.
+// The RPC per line format, the events format and the binary format are supported:
+// events are reassembled into RPCs which are returned once the stream has ended.
+type Reader struct {
+	r      *bufio.Reader
+	binary *binaryReader
+
+	decoder *json.Decoder
+	streams *reassembler
+	pending []*internal.RPC
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Next returns the next RPC in the dump or io.EOF once all have been read
+func (r *Reader) Next() (*internal.RPC, error) {
+	if r.binary == nil && r.decoder == nil {
+		// detect the format of the dump
+		if IsBinary(r.r) {
+			r.r.Discard(len(binaryHeader))
+			r.binary = &binaryReader{r.r}
+		} else {
+			r.decoder = json.NewDecoder(r.r)
+			r.streams = newReassembler()
+		}
+	}
+	if r.binary != nil {
+		return r.binary.next()
+	}
+
+	for len(r.pending) == 0 {
+		var line json.RawMessage
+		err := r.decoder.Decode(&line)
+		if err == io.EOF {
+			// return any streams that never ended (e.g. the dump was cut short)
+			r.pending = r.streams.flush()
+			if len(r.pending) == 0 {
+				return nil, io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var probe struct {
+			StreamID string `json:"stream_id"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			return nil, err
+		}
+
+		if probe.StreamID == "" {
+			rpc := &internal.RPC{}
+			if err := json.Unmarshal(line, rpc); err != nil {
+				return nil, err
+			}
+			return rpc, nil
+		}
+
+		event := &internal.StreamEvent{}
+		if err := json.Unmarshal(line, event); err != nil {
+			return nil, err
+		}
+		rpc, err := r.streams.add(event)
+		if err != nil {
+			return nil, err
+		}
+		if rpc != nil {
+			return rpc, nil
+		}
+	}
+
+	rpc := r.pending[0]
+	r.pending = r.pending[1:]
+	return rpc, nil
+}
+
+// ReadAll reads all the RPCs in a dump
+func ReadAll(r io.Reader) ([]*internal.RPC, error) {
+	reader := NewReader(r)
+	var rpcs []*internal.RPC
+	for {
+		rpc, err := reader.Next()
+		if err == io.EOF {
+			return rpcs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rpcs = append(rpcs, rpc)
+	}
+}
+
+type reassembler struct {
+	streams map[string]*internal.RPC
+	order   []string // stream IDs in the order they started
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		streams: map[string]*internal.RPC{},
+	}
+}
+
+// add applies an event to its stream and returns the complete RPC if the stream has ended
+func (r *reassembler) add(event *internal.StreamEvent) (*internal.RPC, error) {
+	if event.Event == internal.StreamMirror {
+		// mirror events come after the stream has ended so aren't part of the RPC
+		return nil, nil
+	}
+	rpc, ok := r.streams[event.StreamID]
+	if !ok && event.Event != internal.StreamStart {
+		return nil, fmt.Errorf("got %s event for unknown stream %s", event.Event, event.StreamID)
+	}
+
+	switch event.Event {
+	case internal.StreamStart:
+		if ok {
+			return nil, fmt.Errorf("got duplicate start event for stream %s", event.StreamID)
+		}
+		r.streams[event.StreamID] = &internal.RPC{
+			Service:           event.Service,
+			Method:            event.Method,
+			Messages:          []*internal.Message{},
+			Metadata:          event.Metadata,
+			ClientCertificate: event.ClientCertificate,
+		}
+		r.order = append(r.order, event.StreamID)
+	case internal.StreamMessage:
+		rpc.Messages = append(rpc.Messages, event.Message)
+	case internal.StreamHeaders:
+		rpc.MetadataRespHeaders = metadata.Join(rpc.MetadataRespHeaders, event.Metadata)
+	case internal.StreamTrailer:
+		rpc.MetadataRespTrailers = event.Metadata
+	case internal.StreamEnd:
+		rpc.Status = event.Status
+		rpc.Route = event.Route
+		r.remove(event.StreamID)
+		return rpc, nil
+	default:
+		return nil, fmt.Errorf("unknown event type %q", event.Event)
+	}
+	return nil, nil
+}
+
+func (r *reassembler) remove(streamID string) {
+	delete(r.streams, streamID)
+	for i, id := range r.order {
+		if id == streamID {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (r *reassembler) flush() []*internal.RPC {
+	var rpcs []*internal.RPC
+	for _, id := range r.order {
+		rpcs = append(rpcs, r.streams[id])
+	}
+	r.streams = map[string]*internal.RPC{}
+	r.order = nil
+	return rpcs
+}
@@ -0,0 +1,48 @@
+package dumpfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader_ReassemblesEvents(t *testing.T) {
+	dump := strings.Join([]string{
+		`{"service":"svc","method":"Unary","messages":[{"message_origin":"client","raw_message":"AQ=="}]}`,
+		`{"stream_id":"a","event":"start","service":"svc","method":"Watch","metadata":{":authority":["example.com"]}}`,
+		`{"stream_id":"b","event":"start","service":"svc","method":"Get"}`,
+		`{"stream_id":"a","event":"message","message":{"message_origin":"client","raw_message":"Ag=="}}`,
+		`{"stream_id":"b","event":"end","error":{"code":"NotFound","message":"no such thing"}}`,
+		`{"stream_id":"a","event":"headers","metadata":{"content-type":["application/grpc"]}}`,
+		`{"stream_id":"a","event":"message","message":{"message_origin":"server","raw_message":"Aw=="}}`,
+		`{"stream_id":"a","event":"trailers","metadata":{"trailer-key":["value"]}}`,
+		`{"stream_id":"a","event":"end"}`,
+		`{"stream_id":"c","event":"start","service":"svc","method":"Unfinished"}`,
+	}, "\n")
+
+	rpcs, err := ReadAll(strings.NewReader(dump))
+	require.NoError(t, err)
+	require.Len(t, rpcs, 4)
+
+	require.Equal(t, "/svc/Unary", rpcs[0].StreamName())
+
+	require.Equal(t, "/svc/Get", rpcs[1].StreamName())
+	require.Equal(t, &internal.Status{Code: "NotFound", Message: "no such thing"}, rpcs[1].Status)
+
+	watch := rpcs[2]
+	require.Equal(t, "/svc/Watch", watch.StreamName())
+	require.Nil(t, watch.Status)
+	require.Equal(t, []string{"example.com"}, watch.Metadata.Get(":authority"))
+	require.Equal(t, []string{"application/grpc"}, watch.MetadataRespHeaders.Get("content-type"))
+	require.Equal(t, []string{"value"}, watch.MetadataRespTrailers.Get("trailer-key"))
+	require.Len(t, watch.Messages, 2)
+	require.Equal(t, internal.ClientMessage, watch.Messages[0].MessageOrigin)
+	require.Equal(t, []byte{2}, watch.Messages[0].RawMessage)
+	require.Equal(t, internal.ServerMessage, watch.Messages[1].MessageOrigin)
+	require.Equal(t, []byte{3}, watch.Messages[1].RawMessage)
+
+	// streams that never ended are returned at the end
+	require.Equal(t, "/svc/Unfinished", rpcs[3].StreamName())
+}
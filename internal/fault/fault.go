@@ -0,0 +1,101 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/methodmatch"
+	"google.golang.org/grpc/metadata"
+)
+
+// Rule describes the faults to inject into RPCs matching its service, method and metadata
+type Rule struct {
+	// Glob patterns matched against the fully qualified service name (e.g. foo.v1.*)
+	// and the method name. Empty patterns match everything.
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+	// Glob patterns matched against the values of request metadata keys
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Probability of the rule being applied to a matching RPC (1 by default)
+	Probability *float64 `json:"probability,omitempty"`
+
+	// Delay before the RPC is handled
+	Delay Duration `json:"delay,omitempty"`
+	// Status to end the RPC with
+	Status *internal.Status `json:"status,omitempty"`
+	// Close the client's connection instead of ending the RPC
+	DropConnection bool `json:"drop_connection,omitempty"`
+	// End the RPC after this many server messages have been sent. The RPC ends
+	// with Status, by dropping the connection or otherwise successfully.
+	TruncateAfter *int `json:"truncate_after,omitempty"`
+	// Change a random byte of each server message
+	Corrupt bool `json:"corrupt,omitempty"`
+}
+
+// Duration is a time.Duration written as a string in JSON (e.g. "500ms")
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Validate checks that the rule's patterns and settings are valid
+func (r Rule) Validate() error {
+	if err := methodmatch.ValidatePatterns(r.Service, r.Method); err != nil {
+		return err
+	}
+	if err := methodmatch.ValidateMetadata(r.Metadata); err != nil {
+		return err
+	}
+	if r.Probability != nil && (*r.Probability < 0 || *r.Probability > 1) {
+		return fmt.Errorf("probability must be between 0 and 1 (got %v)", *r.Probability)
+	}
+	if r.Delay < 0 {
+		return fmt.Errorf("delay must not be negative (got %v)", time.Duration(r.Delay))
+	}
+	if r.TruncateAfter != nil && *r.TruncateAfter < 0 {
+		return fmt.Errorf("truncate_after must not be negative (got %d)", *r.TruncateAfter)
+	}
+	if r.Status != nil && r.DropConnection {
+		return fmt.Errorf("a rule can't both end RPCs with a status and drop the connection")
+	}
+	return nil
+}
+
+func (r Rule) matches(fullMethod string, md metadata.MD) bool {
+	return methodmatch.Method(r.Service, r.Method, fullMethod) && methodmatch.Metadata(r.Metadata, md)
+}
+
+func (r Rule) probability() float64 {
+	if r.Probability == nil {
+		return 1
+	}
+	return *r.Probability
+}
+
+// LoadRules reads a JSON array of Rules
+func LoadRules(rulesPath string) ([]Rule, error) {
+	var rules []Rule
+	err := methodmatch.LoadRules(rulesPath, "fault rule", &rules, func(i int) error {
+		return rules[i].Validate()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
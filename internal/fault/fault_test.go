@@ -0,0 +1,91 @@
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type mockServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent [][]byte
+}
+
+func (m *mockServerStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockServerStream) SendMsg(msg interface{}) error {
+	m.sent = append(m.sent, msg.([]byte))
+	return nil
+}
+
+func TestRuleMatches(t *testing.T) {
+	var rule Rule
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"service": "foo.v1.*",
+		"method": "Get*",
+		"metadata": {"x-tenant": "test-*"},
+		"delay": "100ms"
+	}`), &rule))
+	require.NoError(t, rule.Validate())
+
+	tenant := metadata.Pairs("x-tenant", "test-1")
+	require.True(t, rule.matches("/foo.v1.Service/GetThing", tenant))
+	require.False(t, rule.matches("/foo.v1.Service/ListThings", tenant))
+	require.False(t, rule.matches("/bar.v1.Service/GetThing", tenant))
+	require.False(t, rule.matches("/foo.v1.Service/GetThing", metadata.Pairs("x-tenant", "prod")))
+	require.False(t, rule.matches("/foo.v1.Service/GetThing", nil))
+
+	require.True(t, Rule{}.matches("/foo.v1.Service/GetThing", nil))
+	require.Error(t, Rule{Service: "["}.Validate())
+	probability := 2.0
+	require.Error(t, Rule{Probability: &probability}.Validate())
+}
+
+func TestInjector(t *testing.T) {
+	ctx := context.Background()
+	info := &grpc.StreamServerInfo{FullMethod: "/foo.v1.Service/GetThing"}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			if err := ss.SendMsg([]byte{1, 2, 3}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	never := 0.0
+	two := 2
+	injector := NewInjector(logrus.New(), []Rule{
+		{Method: "GetThing", Probability: &never, Status: &internal.Status{Code: "Internal"}},
+		{Method: "GetThing", TruncateAfter: &two, Status: &internal.Status{Code: "Unavailable", Message: "truncated"}},
+		{Method: "Corrupt", Corrupt: true},
+	}, nil)
+
+	// the first rule never applies so the stream is truncated by the second
+	ss := &mockServerStream{ctx: ctx}
+	err := injector.Intercept(nil, ss, info, handler)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Len(t, ss.sent, 2)
+
+	ss = &mockServerStream{ctx: ctx}
+	require.NoError(t, injector.Intercept(nil, ss, &grpc.StreamServerInfo{FullMethod: "/foo.v1.Service/Corrupt"}, handler))
+	require.Len(t, ss.sent, 3)
+	for _, message := range ss.sent {
+		require.NotEqual(t, []byte{1, 2, 3}, message)
+	}
+
+	ss = &mockServerStream{ctx: ctx}
+	require.NoError(t, injector.Intercept(nil, ss, &grpc.StreamServerInfo{FullMethod: "/foo.v1.Service/Other"}, handler))
+	require.Len(t, ss.sent, 3)
+}
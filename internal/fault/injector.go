@@ -0,0 +1,149 @@
+package fault
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var errTruncated = errors.New("stream truncated by injected fault")
+
+// Injector injects the faults described by the first matching rule into each RPC
+type Injector struct {
+	rules  []Rule
+	logger logrus.FieldLogger
+	// drop closes the connection of the client making an RPC
+	drop func(ctx context.Context) error
+
+	// guards random which isn't safe for concurrent use
+	sync.Mutex
+	random *rand.Rand
+}
+
+func NewInjector(logger logrus.FieldLogger, rules []Rule, drop func(ctx context.Context) error) *Injector {
+	return &Injector{
+		rules:  rules,
+		logger: logger,
+		drop:   drop,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Intercept implements a grpc.StreamServerInterceptor
+func (i *Injector) Intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	rule := i.choose(info.FullMethod, md)
+	if rule == nil {
+		return handler(srv, ss)
+	}
+	i.logger.WithField("method", info.FullMethod).Info("Injecting fault")
+
+	if rule.Delay > 0 {
+		timer := time.NewTimer(time.Duration(rule.Delay))
+		select {
+		case <-timer.C:
+		case <-ss.Context().Done():
+			timer.Stop()
+			return ss.Context().Err()
+		}
+	}
+
+	if rule.TruncateAfter == nil {
+		switch {
+		case rule.DropConnection:
+			return i.dropConnection(ss.Context())
+		case rule.Status != nil:
+			return rule.Status.Err()
+		case !rule.Corrupt:
+			return handler(srv, ss)
+		}
+	}
+
+	faulty := &faultyServerStream{
+		ServerStream: ss,
+		injector:     i,
+		rule:         rule,
+	}
+	err := handler(srv, faulty)
+	if atomic.LoadInt32(&faulty.truncated) == 0 {
+		return err
+	}
+	switch {
+	case rule.DropConnection:
+		return i.dropConnection(ss.Context())
+	case rule.Status != nil:
+		return rule.Status.Err()
+	default:
+		return nil
+	}
+}
+
+// choose returns the first rule matching the RPC which is picked according to its probability
+func (i *Injector) choose(fullMethod string, md metadata.MD) *Rule {
+	for n := range i.rules {
+		rule := &i.rules[n]
+		if rule.matches(fullMethod, md) && i.float64() < rule.probability() {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (i *Injector) dropConnection(ctx context.Context) error {
+	if i.drop != nil {
+		if err := i.drop(ctx); err != nil {
+			i.logger.WithError(err).Warn("Failed to drop connection")
+		}
+	}
+	return status.Error(codes.Unavailable, "connection dropped by injected fault")
+}
+
+func (i *Injector) float64() float64 {
+	i.Lock()
+	defer i.Unlock()
+	return i.random.Float64()
+}
+
+// corrupt returns a copy of the message with a random byte changed
+func (i *Injector) corrupt(m interface{}) interface{} {
+	message, ok := m.([]byte)
+	if !ok || len(message) == 0 {
+		return m
+	}
+	i.Lock()
+	defer i.Unlock()
+	corrupted := append([]byte(nil), message...)
+	corrupted[i.random.Intn(len(corrupted))] ^= byte(1 + i.random.Intn(255))
+	return corrupted
+}
+
+// faultyServerStream corrupts and truncates the messages sent to the client
+type faultyServerStream struct {
+	grpc.ServerStream
+	injector *Injector
+	rule     *Rule
+	sent     int
+	// set (atomically) once the stream has been truncated
+	truncated int32
+}
+
+func (s *faultyServerStream) SendMsg(m interface{}) error {
+	if s.rule.TruncateAfter != nil && s.sent >= *s.rule.TruncateAfter {
+		atomic.StoreInt32(&s.truncated, 1)
+		return errTruncated
+	}
+	if s.rule.Corrupt {
+		m = s.injector.corrupt(m)
+	}
+	s.sent++
+	return s.ServerStream.SendMsg(m)
+}
@@ -0,0 +1,321 @@
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/versionflag"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// Writer writes RPCs as the entries of a HAR 1.2 document (http://www.softwareishard.com/blog/har-12-spec/).
+// Entries are written as soon as they're added so Close must be called to finish the document.
+type Writer struct {
+	sync.Mutex
+	w       io.Writer
+	started bool
+	closed  bool
+	entries int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+	}
+}
+
+func header() string {
+	creatorVersion := versionflag.Version()
+	if creatorVersion == "" {
+		creatorVersion = "dev"
+	}
+	return fmt.Sprintf(`{"log":{"version":"1.2","creator":{"name":"grpc-tools","version":%q},"entries":[`, creatorVersion)
+}
+
+const footer = "\n]}}\n"
+
+func (h *Writer) Write(rpc *internal.RPC) error {
+	entry, err := json.Marshal(NewEntry(rpc))
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		return errors.New("can't write an entry to a closed HAR document")
+	}
+	if !h.started {
+		if _, err := io.WriteString(h.w, header()); err != nil {
+			return err
+		}
+		h.started = true
+	}
+	separator := "\n"
+	if h.entries > 0 {
+		separator = ",\n"
+	}
+	if _, err := io.WriteString(h.w, separator); err != nil {
+		return err
+	}
+	if _, err := h.w.Write(entry); err != nil {
+		return err
+	}
+	h.entries++
+	return nil
+}
+
+// Close finishes the HAR document. No more entries can be written afterwards.
+func (h *Writer) Close() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	if !h.started {
+		if _, err := io.WriteString(h.w, header()); err != nil {
+			return err
+		}
+	}
+	h.started = true
+	_, err := io.WriteString(h.w, footer)
+	return err
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+
+	// custom fields (prefixed by an underscore as per the spec)
+	Service  string          `json:"_grpcService"`
+	Method   string          `json:"_grpcMethod"`
+	Status   string          `json:"_grpcStatus"`
+	Trailers []Header        `json:"_grpcTrailers"`
+	Route    *internal.Route `json:"_grpcRoute,omitempty"`
+	// the response from the mirror (if any) is kept as is
+	Mirror *internal.Mirror `json:"_grpcMirror,omitempty"`
+}
+
+type Request struct {
+	Method      string        `json:"method"`
+	URL         string        `json:"url"`
+	HTTPVersion string        `json:"httpVersion"`
+	Cookies     []interface{} `json:"cookies"`
+	Headers     []Header      `json:"headers"`
+	QueryString []interface{} `json:"queryString"`
+	PostData    PostData      `json:"postData"`
+	HeadersSize int           `json:"headersSize"`
+	BodySize    int           `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int           `json:"status"`
+	StatusText  string        `json:"statusText"`
+	HTTPVersion string        `json:"httpVersion"`
+	Cookies     []interface{} `json:"cookies"`
+	Headers     []Header      `json:"headers"`
+	Content     Content       `json:"content"`
+	RedirectURL string        `json:"redirectURL"`
+	HeadersSize int           `json:"headersSize"`
+	BodySize    int           `json:"bodySize"`
+}
+
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Timings are in milliseconds
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewEntry converts an RPC to a HAR entry.
+// Message bodies are the decoded messages as JSON (or base64 encoded raw messages if they couldn't be decoded).
+// Streams with multiple messages have a JSON array of messages as the body.
+func NewEntry(rpc *internal.RPC) *Entry {
+	status := "OK"
+	if rpc.Status != nil {
+		status = rpc.Status.Code
+	}
+	contentType := "application/grpc"
+	if values := rpc.Metadata.Get("content-type"); len(values) > 0 {
+		contentType = values[0]
+	}
+
+	requestBody, requestSize := body(rpc.Messages, internal.ClientMessage)
+	responseBody, responseSize := body(rpc.Messages, internal.ServerMessage)
+	entry := &Entry{
+		Request: Request{
+			Method:      "POST",
+			URL:         url(rpc),
+			HTTPVersion: "HTTP/2.0",
+			Cookies:     []interface{}{},
+			Headers:     headers(rpc.Metadata),
+			QueryString: []interface{}{},
+			PostData: PostData{
+				MimeType: contentType,
+				Text:     requestBody,
+			},
+			HeadersSize: -1,
+			BodySize:    requestSize,
+		},
+		Response: Response{
+			// gRPC errors are sent with a 200 status, the gRPC status is in the trailers
+			Status:      200,
+			StatusText:  "OK",
+			HTTPVersion: "HTTP/2.0",
+			Cookies:     []interface{}{},
+			Headers:     headers(rpc.MetadataRespHeaders),
+			Content: Content{
+				Size:     responseSize,
+				MimeType: contentType,
+				Text:     responseBody,
+			},
+			HeadersSize: -1,
+			BodySize:    responseSize,
+		},
+		Service:  rpc.Service,
+		Method:   rpc.Method,
+		Status:   status,
+		Trailers: headers(rpc.MetadataRespTrailers),
+		Route:    rpc.Route,
+		Mirror:   rpc.Mirror,
+	}
+	entry.StartedDateTime, entry.Timings = timings(rpc.Messages)
+	entry.Time = entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+	return entry
+}
+
+func url(rpc *internal.RPC) string {
+	scheme := "http"
+	for _, forwarded := range rpc.Metadata.Get("forwarded") {
+		if strings.Contains(forwarded, "proto=https") {
+			scheme = "https"
+		}
+	}
+	authority := "unknown"
+	if values := rpc.Metadata.Get(":authority"); len(values) > 0 {
+		authority = values[0]
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, authority, rpc.StreamName())
+}
+
+func headers(md metadata.MD) []Header {
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	headers := []Header{}
+	for _, key := range keys {
+		for _, value := range md[key] {
+			headers = append(headers, Header{Name: key, Value: value})
+		}
+	}
+	return headers
+}
+
+func body(messages []*internal.Message, origin internal.MessageOrigin) (string, int) {
+	var bodies []json.RawMessage
+	size := 0
+	for _, message := range messages {
+		if message.MessageOrigin != origin {
+			continue
+		}
+		size += len(message.RawMessage)
+		decoded, err := json.Marshal(message.Message)
+		if message.Message == nil || err != nil || string(decoded) == "null" {
+			decoded, _ = json.Marshal(base64.StdEncoding.EncodeToString(message.RawMessage))
+		}
+		bodies = append(bodies, decoded)
+	}
+
+	switch len(bodies) {
+	case 0:
+		return "", 0
+	case 1:
+		return string(bodies[0]), size
+	default:
+		text, _ := json.Marshal(bodies)
+		return string(text), size
+	}
+}
+
+// timings splits the RPC into the time spent sending client messages,
+// waiting for the first server message and receiving server messages.
+// It also returns the time the RPC started (zero if no messages have timestamps).
+func timings(messages []*internal.Message) (time.Time, Timings) {
+	var firstClient, lastClient, firstServer, lastServer time.Time
+	for _, message := range messages {
+		if message.Timestamp.IsZero() {
+			continue
+		}
+		switch message.MessageOrigin {
+		case internal.ClientMessage:
+			if firstClient.IsZero() {
+				firstClient = message.Timestamp
+			}
+			lastClient = message.Timestamp
+		case internal.ServerMessage:
+			if firstServer.IsZero() {
+				firstServer = message.Timestamp
+			}
+			lastServer = message.Timestamp
+		}
+	}
+
+	switch {
+	case firstClient.IsZero() && firstServer.IsZero():
+		// without timestamps (e.g. a converted fixture) there's no start time so the
+		// zero time is used to keep the output deterministic
+		return time.Time{}, Timings{}
+	case firstClient.IsZero():
+		return firstServer, Timings{Receive: milliseconds(lastServer.Sub(firstServer))}
+	case firstServer.IsZero():
+		return firstClient, Timings{Send: milliseconds(lastClient.Sub(firstClient))}
+	}
+
+	t := Timings{
+		Send:    milliseconds(lastClient.Sub(firstClient)),
+		Receive: milliseconds(lastServer.Sub(firstServer)),
+	}
+	if firstServer.After(lastClient) {
+		t.Wait = milliseconds(firstServer.Sub(lastClient))
+	}
+	return firstClient, t
+}
+
+func milliseconds(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,78 @@
+package har
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWriter(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rpc := &internal.RPC{
+		Service: "foo.Service",
+		Method:  "Get",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, RawMessage: []byte{1}, Message: map[string]interface{}{"id": "1"}, Timestamp: start},
+			{MessageOrigin: internal.ServerMessage, RawMessage: []byte{2, 3}, Timestamp: start.Add(10 * time.Millisecond)},
+			{MessageOrigin: internal.ServerMessage, RawMessage: []byte{4}, Message: map[string]interface{}{"name": "bar"}, Timestamp: start.Add(15 * time.Millisecond)},
+		},
+		Status: &internal.Status{Code: "NotFound"},
+		Metadata: metadata.MD{
+			":authority":   []string{"api.example.com"},
+			"content-type": []string{"application/grpc-web+proto"},
+			"forwarded":    []string{"proto=https"},
+		},
+		MetadataRespHeaders:  metadata.MD{"x-header": []string{"a"}},
+		MetadataRespTrailers: metadata.MD{"grpc-status": []string{"5"}},
+	}
+
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.Write(rpc))
+	require.NoError(t, w.Write(rpc))
+	require.NoError(t, w.Close())
+	require.Error(t, w.Write(rpc), "entries can't be written after the footer")
+
+	var doc struct {
+		Log struct {
+			Version string  `json:"version"`
+			Entries []Entry `json:"entries"`
+		} `json:"log"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &doc))
+	require.Equal(t, "1.2", doc.Log.Version)
+	require.Len(t, doc.Log.Entries, 2)
+
+	entry := doc.Log.Entries[0]
+	require.True(t, start.Equal(entry.StartedDateTime))
+	require.Equal(t, "https://api.example.com/foo.Service/Get", entry.Request.URL)
+	require.Equal(t, `{"id":"1"}`, entry.Request.PostData.Text)
+	require.Equal(t, "application/grpc-web+proto", entry.Request.PostData.MimeType)
+	require.Equal(t, `["AgM=",{"name":"bar"}]`, entry.Response.Content.Text)
+	require.Equal(t, 3, entry.Response.BodySize)
+	require.Equal(t, []Header{{Name: "x-header", Value: "a"}}, entry.Response.Headers)
+	require.Equal(t, []Header{{Name: "grpc-status", Value: "5"}}, entry.Trailers)
+	require.Equal(t, "NotFound", entry.Status)
+	require.Equal(t, Timings{Send: 0, Wait: 10, Receive: 5}, entry.Timings)
+	require.Equal(t, float64(15), entry.Time)
+}
+
+func TestTimings_NoTimestamps(t *testing.T) {
+	start, got := timings([]*internal.Message{
+		{MessageOrigin: internal.ClientMessage, RawMessage: []byte{1}},
+		{MessageOrigin: internal.ServerMessage, RawMessage: []byte{2}},
+	})
+	require.True(t, start.IsZero())
+	require.Equal(t, Timings{}, got)
+}
+
+func TestWriter_Empty(t *testing.T) {
+	out := &bytes.Buffer{}
+	require.NoError(t, NewWriter(out).Close())
+	require.True(t, json.Valid(out.Bytes()))
+}
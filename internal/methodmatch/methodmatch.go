@@ -0,0 +1,101 @@
+package methodmatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// This package implements the matching of RPCs against the glob patterns
+// used by fault injection rules, rewrite rules and routes.
+
+// ValidatePatterns checks that the patterns are valid glob patterns
+func ValidatePatterns(patterns ...string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ValidateMetadata checks that the patterns for each metadata key are valid glob patterns
+func ValidateMetadata(patterns map[string]string) error {
+	for key, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q for metadata key %s: %v", pattern, key, err)
+		}
+	}
+	return nil
+}
+
+// Pattern returns whether value matches the glob pattern. Empty patterns match everything.
+func Pattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// Any returns whether any of the values match the glob pattern
+func Any(pattern string, values []string) bool {
+	for _, value := range values {
+		if Pattern(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Method returns whether the service and method names of fullMethod match the patterns
+func Method(servicePattern, methodPattern, fullMethod string) bool {
+	service, method := SplitMethod(fullMethod)
+	return Pattern(servicePattern, service) && Pattern(methodPattern, method)
+}
+
+// Metadata returns whether every key in patterns has a value in md matching its pattern
+func Metadata(patterns map[string]string, md metadata.MD) bool {
+	for key, pattern := range patterns {
+		if !Any(pattern, md.Get(key)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SplitMethod splits /foo.v1.Service/Method into the service and method names
+func SplitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndex(fullMethod, "/")
+	if i < 0 {
+		return "", fullMethod
+	}
+	return fullMethod[:i], fullMethod[i+1:]
+}
+
+// LoadRules reads a JSON array from rulesPath into rules (a pointer to a slice)
+// and then calls validate with the index of each element. kind names the elements in errors.
+func LoadRules(rulesPath, kind string, rules interface{}, validate func(i int) error) error {
+	rulesFile, err := os.Open(rulesPath)
+	if err != nil {
+		return err
+	}
+	defer rulesFile.Close()
+
+	if err := json.NewDecoder(rulesFile).Decode(rules); err != nil {
+		return errors.Wrapf(err, "failed to parse %s", rulesPath)
+	}
+	for i := 0; i < reflect.ValueOf(rules).Elem().Len(); i++ {
+		if err := validate(i); err != nil {
+			return errors.Wrapf(err, "invalid %s %d in %s", kind, i, rulesPath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package methodmatch
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMethod(t *testing.T) {
+	require.True(t, Method("", "", "/foo.v1.Service/Get"))
+	require.True(t, Method("foo.*", "Get*", "/foo.v1.Service/GetThing"))
+	require.False(t, Method("foo.*", "Get*", "/foo.v1.Service/ListThings"))
+	require.False(t, Method("bar.*", "", "/foo.v1.Service/GetThing"))
+
+	service, method := SplitMethod("/foo.v1.Service/Get")
+	require.Equal(t, "foo.v1.Service", service)
+	require.Equal(t, "Get", method)
+}
+
+func TestMetadata(t *testing.T) {
+	md := metadata.Pairs("x-tenant", "a", "x-tenant", "test-b")
+	require.True(t, Metadata(nil, md))
+	require.True(t, Metadata(map[string]string{"x-tenant": "test-*"}, md))
+	require.False(t, Metadata(map[string]string{"x-tenant": "prod-*"}, md))
+	require.False(t, Metadata(map[string]string{"x-other": "*"}, md))
+
+	require.Error(t, ValidatePatterns("foo", "[foo"))
+	require.Error(t, ValidateMetadata(map[string]string{"x-tenant": "[foo"}))
+}
+
+func TestLoadRules(t *testing.T) {
+	rulesFile, err := ioutil.TempFile("", "rules")
+	require.NoError(t, err)
+	defer os.Remove(rulesFile.Name())
+	_, err = rulesFile.WriteString(`[{"method": "Get"}, {"method": ""}]`)
+	require.NoError(t, err)
+	require.NoError(t, rulesFile.Close())
+
+	var rules []struct {
+		Method string `json:"method"`
+	}
+	err = LoadRules(rulesFile.Name(), "rule", &rules, func(i int) error {
+		if rules[i].Method == "" {
+			return errors.New("no method")
+		}
+		return nil
+	})
+	require.EqualError(t, err, "invalid rule 1 in "+rulesFile.Name()+": no method")
+	require.Len(t, rules, 2)
+}
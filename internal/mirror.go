@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Mirror is the response to an RPC that was also sent to a mirror (shadow) server.
+// Only the server messages are recorded as the client messages are the same as the RPC's.
+type Mirror struct {
+	Destination          string      `json:"destination"`
+	Messages             []*Message  `json:"messages"`
+	Status               *Status     `json:"error,omitempty"`
+	MetadataRespHeaders  metadata.MD `json:"metadata_response_headers"`
+	MetadataRespTrailers metadata.MD `json:"metadata_response_trailers"`
+	// how the mirror's response differs from the RPC's
+	Differences []string `json:"differences,omitempty"`
+	// set if some client messages weren't sent to the mirror (because it fell too far behind)
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// MirrorDifferences compares the status and server messages of an RPC and its mirror.
+// Incomplete mirrors aren't compared as they weren't sent the same messages.
+func MirrorDifferences(rpc *RPC, mirror *Mirror) []string {
+	if mirror.Incomplete {
+		return nil
+	}
+	var differences []string
+	if code, mirrorCode := statusCode(rpc.Status), statusCode(mirror.Status); code != mirrorCode {
+		differences = append(differences, fmt.Sprintf("status %s != %s", code, mirrorCode))
+	}
+
+	var messages []*Message
+	for _, message := range rpc.Messages {
+		if message.MessageOrigin == ServerMessage {
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) != len(mirror.Messages) {
+		differences = append(differences, fmt.Sprintf("%d server messages != %d", len(messages), len(mirror.Messages)))
+	}
+	for i := 0; i < len(messages) && i < len(mirror.Messages); i++ {
+		if !bytes.Equal(messages[i].RawMessage, mirror.Messages[i].RawMessage) {
+			differences = append(differences, fmt.Sprintf("server message %d differs", i))
+		}
+	}
+	return differences
+}
+
+func statusCode(s *Status) string {
+	if s == nil {
+		return "OK"
+	}
+	return s.Code
+}
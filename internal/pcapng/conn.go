@@ -0,0 +1,74 @@
+package pcapng
+
+import (
+	"net"
+)
+
+// conn records the data read from and written to a net.Conn
+type conn struct {
+	net.Conn
+	connection *Connection
+	// whether this side of the connection is the server
+	server bool
+}
+
+// WrapServerConn records a connection accepted by a server:
+// data read is from the client and data written is from the server.
+func (w *Writer) WrapServerConn(c net.Conn, description string) net.Conn {
+	return &conn{
+		Conn:       c,
+		connection: w.NewConnection(c.RemoteAddr(), c.LocalAddr(), description),
+		server:     true,
+	}
+}
+
+// WrapClientConn records a connection dialed by a client:
+// data written is from the client and data read is from the server.
+func (w *Writer) WrapClientConn(c net.Conn, description string) net.Conn {
+	return &conn{
+		Conn:       c,
+		connection: w.NewConnection(c.LocalAddr(), c.RemoteAddr(), description),
+		server:     false,
+	}
+}
+
+// Unwrap stops recording a connection wrapped by WrapServerConn or WrapClientConn
+// (e.g. because it's about to be wrapped again in a different way) and returns the underlying connection.
+// Other connections are returned unchanged.
+func Unwrap(c net.Conn) net.Conn {
+	wrapped, ok := c.(*conn)
+	if !ok {
+		return c
+	}
+	_ = wrapped.connection.Close()
+	return wrapped.Conn
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if c.server {
+			_ = c.connection.ClientData(b[:n])
+		} else {
+			_ = c.connection.ServerData(b[:n])
+		}
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		if c.server {
+			_ = c.connection.ServerData(b[:n])
+		} else {
+			_ = c.connection.ClientData(b[:n])
+		}
+	}
+	return n, err
+}
+
+func (c *conn) Close() error {
+	_ = c.connection.Close()
+	return c.Conn.Close()
+}
@@ -0,0 +1,184 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// All connections are written with the server on port 80 so that
+// Wireshark's HTTP dissector picks them up and detects HTTP/2.
+const serverPort = 80
+
+var (
+	fallbackClientIP = net.IPv4(10, 0, 0, 1).To4()
+	fallbackServerIP = net.IPv4(10, 0, 0, 2).To4()
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+type endpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32
+}
+
+// Connection synthesizes the packets of a TCP connection carrying the data sent by each side.
+// Client ports are allocated sequentially so that each connection can be told apart
+// (e.g. a CONNECT request and the connection tunnelled through it) and the real
+// addresses are recorded in a comment on the first packet.
+type Connection struct {
+	sync.Mutex
+	w       *Writer
+	client  endpoint
+	server  endpoint
+	comment string
+	started bool
+	closed  bool
+}
+
+func (w *Writer) NewConnection(client, server net.Addr, description string) *Connection {
+	return &Connection{
+		w: w,
+		client: endpoint{
+			ip:   ipv4(client, fallbackClientIP),
+			port: w.allocatePort(),
+		},
+		server: endpoint{
+			ip:   ipv4(server, fallbackServerIP),
+			port: serverPort,
+		},
+		comment: fmt.Sprintf("%s: %s -> %s", description, client, server),
+	}
+}
+
+// ClientData records data sent by the client to the server
+func (c *Connection) ClientData(data []byte) error {
+	return c.data(&c.client, &c.server, data)
+}
+
+// ServerData records data sent by the server to the client
+func (c *Connection) ServerData(data []byte) error {
+	return c.data(&c.server, &c.client, data)
+}
+
+func (c *Connection) data(from, to *endpoint, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed || len(data) == 0 {
+		return nil
+	}
+	if err := c.start(); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		segment := data
+		if len(segment) > maxSegmentSize {
+			segment = segment[:maxSegmentSize]
+		}
+		data = data[len(segment):]
+		if err := c.packet(from, to, tcpPSH|tcpACK, segment, ""); err != nil {
+			return err
+		}
+		from.seq += uint32(len(segment))
+	}
+	return nil
+}
+
+// Close records the connection being closed. No more data is recorded afterwards.
+func (c *Connection) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if !c.started {
+		// nothing was recorded so there's no need to write anything
+		return nil
+	}
+	if err := c.packet(&c.client, &c.server, tcpFIN|tcpACK, nil, ""); err != nil {
+		return err
+	}
+	c.client.seq++
+	if err := c.packet(&c.server, &c.client, tcpFIN|tcpACK, nil, ""); err != nil {
+		return err
+	}
+	c.server.seq++
+	return c.packet(&c.client, &c.server, tcpACK, nil, "")
+}
+
+// start writes the TCP handshake the first time data is recorded
+func (c *Connection) start() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	if err := c.packet(&c.client, &c.server, tcpSYN, nil, c.comment); err != nil {
+		return err
+	}
+	c.client.seq++
+	if err := c.packet(&c.server, &c.client, tcpSYN|tcpACK, nil, ""); err != nil {
+		return err
+	}
+	c.server.seq++
+	return c.packet(&c.client, &c.server, tcpACK, nil, "")
+}
+
+func (c *Connection) packet(from, to *endpoint, flags byte, payload []byte, comment string) error {
+	packet := make([]byte, 40+len(payload))
+
+	ip := packet[:20]
+	ip[0] = 0x45 // IPv4, 5 word header
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(packet)))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                                 // TTL
+	ip[9] = 6                                  // TCP
+	copy(ip[12:16], from.ip)
+	copy(ip[16:20], to.ip)
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+	tcp := packet[20:]
+	binary.BigEndian.PutUint16(tcp[0:], from.port)
+	binary.BigEndian.PutUint16(tcp[2:], to.port)
+	binary.BigEndian.PutUint32(tcp[4:], from.seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], to.seq)
+	}
+	tcp[12] = 5 << 4 // 5 word header
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF) // window size
+	copy(tcp[20:], payload)
+
+	// the TCP checksum includes a pseudo header of the addresses, protocol and length
+	pseudo := uint32(0)
+	for i := 12; i < 20; i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(ip[i:]))
+	}
+	pseudo += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, pseudo))
+
+	return c.w.writePacket(time.Now(), packet, comment)
+}
+
+// checksum is the internet checksum (RFC 1071)
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
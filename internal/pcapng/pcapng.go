@@ -0,0 +1,140 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// This file implements just enough of the pcapng format (https://datatracker.ietf.org/doc/draft-tuexen-opsawg-pcapng/)
+// to write synthesized TCP/IPv4 packets that Wireshark can reassemble into streams.
+
+const (
+	blockTypeSectionHeader  = 0x0A0D0D0A
+	blockTypeInterface      = 0x00000001
+	blockTypeEnhancedPacket = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	// packets start with the IP header (no link layer header)
+	linkTypeRaw = 101
+
+	optionEnd       = 0
+	optionComment   = 1
+	optionTimestamp = 9 // if_tsresol
+
+	// keep segments well under the maximum IPv4 packet size
+	maxSegmentSize = 16 * 1024
+)
+
+var order = binary.LittleEndian
+
+// Writer writes packets to a pcapng file. It is safe for concurrent use.
+type Writer struct {
+	sync.Mutex
+	w        io.Writer
+	nextPort uint16
+}
+
+// NewWriter writes the pcapng section and interface headers to w
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{
+		w:        w,
+		nextPort: 10000,
+	}
+
+	shb := make([]byte, 16)
+	order.PutUint32(shb[0:], byteOrderMagic)
+	order.PutUint16(shb[4:], 1)                  // major version
+	order.PutUint16(shb[6:], 0)                  // minor version
+	order.PutUint64(shb[8:], 0xFFFFFFFFFFFFFFFF) // section length unknown
+	if err := writer.writeBlock(blockTypeSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	order.PutUint16(idb[0:], linkTypeRaw)
+	order.PutUint32(idb[4:], 0) // no snapshot length limit
+	// timestamps are in nanoseconds
+	idb = appendOption(idb, optionTimestamp, []byte{9})
+	idb = appendOption(idb, optionEnd, nil)
+	if err := writer.writeBlock(blockTypeInterface, idb); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *Writer) writeBlock(blockType uint32, body []byte) error {
+	length := 12 + len(body)
+	block := make([]byte, 0, length)
+	block = appendUint32(block, blockType)
+	block = appendUint32(block, uint32(length))
+	block = append(block, body...)
+	block = appendUint32(block, uint32(length))
+
+	w.Lock()
+	defer w.Unlock()
+	_, err := w.w.Write(block)
+	return err
+}
+
+func (w *Writer) writePacket(timestamp time.Time, packet []byte, comment string) error {
+	ts := uint64(timestamp.UnixNano())
+	body := make([]byte, 20, 20+len(packet)+len(comment)+16)
+	order.PutUint32(body[0:], 0) // interface ID
+	order.PutUint32(body[4:], uint32(ts>>32))
+	order.PutUint32(body[8:], uint32(ts))
+	order.PutUint32(body[12:], uint32(len(packet)))
+	order.PutUint32(body[16:], uint32(len(packet)))
+	body = append(body, pad(packet)...)
+	if comment != "" {
+		body = appendOption(body, optionComment, []byte(comment))
+		body = appendOption(body, optionEnd, nil)
+	}
+	return w.writeBlock(blockTypeEnhancedPacket, body)
+}
+
+func (w *Writer) allocatePort() uint16 {
+	w.Lock()
+	defer w.Unlock()
+	port := w.nextPort
+	w.nextPort++
+	if w.nextPort == 0 {
+		w.nextPort = 10000
+	}
+	return port
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = appendUint16(b, code)
+	b = appendUint16(b, uint16(len(value)))
+	return append(b, pad(value)...)
+}
+
+// pad pads b to a multiple of 32 bits
+func pad(b []byte) []byte {
+	if len(b)%4 == 0 {
+		return b
+	}
+	return append(b[:len(b):len(b)], make([]byte, 4-len(b)%4)...)
+}
+
+// ipv4 returns the IPv4 address of addr or fallback if it doesn't have one
+func ipv4(addr net.Addr, fallback net.IP) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if ip := tcpAddr.IP.To4(); ip != nil {
+			return ip
+		}
+	}
+	return fallback
+}
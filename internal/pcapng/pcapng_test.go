@@ -0,0 +1,77 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type block struct {
+	blockType uint32
+	body      []byte
+}
+
+func readBlocks(t *testing.T, b []byte) []block {
+	var blocks []block
+	for len(b) > 0 {
+		require.True(t, len(b) >= 12)
+		length := int(order.Uint32(b[4:]))
+		require.Zero(t, length%4)
+		require.Equal(t, uint32(length), order.Uint32(b[length-4:]))
+		blocks = append(blocks, block{order.Uint32(b), b[8 : length-4]})
+		b = b[length:]
+	}
+	return blocks
+}
+
+func TestConnection(t *testing.T) {
+	out := &bytes.Buffer{}
+	w, err := NewWriter(out)
+	require.NoError(t, err)
+
+	client := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 51234}
+	server := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 443}
+	c := w.NewConnection(client, server, "test connection")
+	require.NoError(t, c.ClientData([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")))
+	require.NoError(t, c.ServerData(make([]byte, maxSegmentSize+1)))
+	require.NoError(t, c.Close())
+	// nothing is recorded after the connection is closed
+	require.NoError(t, c.ClientData([]byte("ignored")))
+
+	blocks := readBlocks(t, out.Bytes())
+	require.Equal(t, uint32(blockTypeSectionHeader), blocks[0].blockType)
+	require.Equal(t, uint32(blockTypeInterface), blocks[1].blockType)
+
+	// handshake, 1 client segment, 2 server segments, FIN exchange
+	packets := blocks[2:]
+	require.Len(t, packets, 3+1+2+3)
+	var flags []byte
+	for i, p := range packets {
+		require.Equal(t, uint32(blockTypeEnhancedPacket), p.blockType)
+		length := order.Uint32(p.body[12:])
+		packet := p.body[20 : 20+length]
+
+		ip, tcp := packet[:20], packet[20:]
+		require.Zero(t, checksum(ip, 0), "packet %d has invalid IP checksum", i)
+		pseudo := uint32(0)
+		for i := 12; i < 20; i += 2 {
+			pseudo += uint32(binary.BigEndian.Uint16(ip[i:]))
+		}
+		pseudo += 6 + uint32(len(tcp))
+		require.Zero(t, checksum(tcp, pseudo), "packet %d has invalid TCP checksum", i)
+
+		if tcp[13]&tcpSYN != 0 && tcp[13]&tcpACK == 0 {
+			require.Contains(t, string(p.body), "test connection: 127.0.0.1:51234 -> 192.168.0.1:443")
+		}
+		require.Contains(t, []uint16{10000, serverPort}, binary.BigEndian.Uint16(tcp[0:]))
+		flags = append(flags, tcp[13])
+	}
+	require.Equal(t, []byte{
+		tcpSYN, tcpSYN | tcpACK, tcpACK,
+		tcpPSH | tcpACK, tcpPSH | tcpACK, tcpPSH | tcpACK,
+		tcpFIN | tcpACK, tcpFIN | tcpACK, tcpACK,
+	}, flags)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
 	"strings"
 )
 
@@ -61,11 +62,20 @@ var messageName = strings.NewReplacer(
 	".", "_",
 )
 
+// unknownMessageFile is the file of the descriptors generated for messages without a known definition
+const unknownMessageFile = "grpc-tools/unknown_message.proto"
+
+// IsUnknownMessage returns whether a message was decoded without a known definition
+// (so the names and types of its fields are guesses)
+func IsUnknownMessage(message *dynamic.Message) bool {
+	return message.GetMessageDescriptor().GetFile().GetName() == unknownMessageFile
+}
+
 type emptyResolver struct{}
 
 func (e emptyResolver) resolveEncoded(fullMethod string, message *internal.Message) (*desc.MessageDescriptor, error) {
 	// Create a new file so that all messages are associated with a file
-	fb := builder.NewFile("") // "" == generate unique filename
+	fb := builder.NewFile(unknownMessageFile)
 	mb := builder.NewMessage(fmt.Sprintf("%s_%s", messageName.Replace(fullMethod), message.MessageOrigin))
 	fb.AddMessage(mb)
 	return mb.Build()
@@ -0,0 +1,247 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// Replacement is the value redacted strings and bytes are replaced with
+const Replacement = "REDACTED"
+
+const hashPrefix = "sha256:"
+
+// A Redactor removes sensitive values from metadata and messages before they are dumped.
+// Metadata keys are matched against glob patterns (e.g. "authorization" or "x-*-token").
+// Message fields are matched against glob patterns of the fully qualified field name
+// i.e. "<package>.<Message>.<field>" (e.g. "foo.v1.LoginRequest.password" or "*.password").
+type Redactor struct {
+	metadataKeys []string
+	fields       []string
+
+	// instead of replacing values with a fixed string, replace them with a hash of
+	// the value so that equal values are still recognisable (e.g. when matching fixtures)
+	hash bool
+}
+
+func New(metadataKeys, fields []string, hash bool) (*Redactor, error) {
+	r := &Redactor{hash: hash}
+	for _, key := range metadataKeys {
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("invalid metadata key pattern %q: %v", key, err)
+		}
+		r.metadataKeys = append(r.metadataKeys, strings.ToLower(key))
+	}
+	for _, field := range fields {
+		if _, err := path.Match(field, ""); err != nil {
+			return nil, fmt.Errorf("invalid field pattern %q: %v", field, err)
+		}
+		r.fields = append(r.fields, field)
+	}
+	return r, nil
+}
+
+// RedactsFields returns whether any message fields are redacted (and so messages need decoding)
+func (r *Redactor) RedactsFields() bool {
+	return r != nil && len(r.fields) > 0
+}
+
+// Metadata returns a copy of md with the values of matching keys redacted.
+// A nil Redactor returns md unchanged.
+func (r *Redactor) Metadata(md metadata.MD) metadata.MD {
+	if r == nil || len(r.metadataKeys) == 0 || md == nil {
+		return md
+	}
+	redacted := metadata.MD{}
+	for key, values := range md {
+		if !matchAny(r.metadataKeys, key) {
+			redacted[key] = values
+			continue
+		}
+		redacted[key] = make([]string, len(values))
+		for i, value := range values {
+			redacted[key][i] = r.redactString(value)
+		}
+	}
+	return redacted
+}
+
+// Message redacts matching fields of the message (and any nested messages) in place.
+// It returns whether any fields were redacted, in which case the message needs re-encoding.
+func (r *Redactor) Message(message *dynamic.Message) bool {
+	if r == nil || len(r.fields) == 0 || message == nil {
+		return false
+	}
+	return r.redactMessage(message)
+}
+
+// DumpedMessage redacts the decoded form of a dumped message and,
+// if anything was redacted, re-encodes its RawMessage to match.
+// If fields are redacted but the message couldn't be decoded (or its definition isn't known),
+// its contents are removed as it's impossible to tell which fields to redact.
+func (r *Redactor) DumpedMessage(message *internal.Message, decoded *dynamic.Message) error {
+	if r.RedactsFields() && (decoded == nil || proto_decoder.IsUnknownMessage(decoded)) && len(message.RawMessage) > 0 {
+		message.RawMessage = nil
+		message.Message = nil
+		return errors.New("removed the contents of a message which couldn't be decoded to redact it")
+	}
+	if !r.Message(decoded) {
+		return nil
+	}
+	raw, err := decoded.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "failed to re-encode redacted message")
+	}
+	message.RawMessage = raw
+	return nil
+}
+
+func (r *Redactor) redactMessage(message *dynamic.Message) bool {
+	redacted := false
+	for _, field := range message.GetMessageDescriptor().GetFields() {
+		if !message.HasField(field) {
+			continue
+		}
+		if matchAny(r.fields, field.GetFullyQualifiedName()) {
+			r.redactField(message, field)
+			redacted = true
+			continue
+		}
+		if field.GetMessageType() == nil {
+			continue
+		}
+
+		// recurse into nested messages
+		var nested []interface{}
+		switch value := message.GetField(field).(type) {
+		case []interface{}:
+			nested = value
+		case map[interface{}]interface{}:
+			for _, v := range value {
+				nested = append(nested, v)
+			}
+		default:
+			nested = []interface{}{value}
+		}
+		for _, n := range nested {
+			if n, ok := n.(*dynamic.Message); ok && r.redactMessage(n) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+func (r *Redactor) redactField(message *dynamic.Message, field *desc.FieldDescriptor) {
+	value := message.GetField(field)
+	switch {
+	case field.IsMap():
+		redacted := map[interface{}]interface{}{}
+		for k, v := range value.(map[interface{}]interface{}) {
+			if v, ok := r.redactScalar(v); ok {
+				redacted[k] = v
+			}
+		}
+		message.SetField(field, redacted)
+
+	case field.IsRepeated():
+		var redacted []interface{}
+		for _, v := range value.([]interface{}) {
+			if v, ok := r.redactScalar(v); ok {
+				redacted = append(redacted, v)
+			}
+		}
+		if len(redacted) == 0 {
+			message.ClearField(field)
+		} else {
+			message.SetField(field, redacted)
+		}
+
+	default:
+		if v, ok := r.redactScalar(value); ok {
+			message.SetField(field, v)
+		} else {
+			message.ClearField(field)
+		}
+	}
+}
+
+// redactScalar replaces string and bytes values.
+// Other types of values can't hold a replacement so are removed.
+func (r *Redactor) redactScalar(value interface{}) (interface{}, bool) {
+	switch value := value.(type) {
+	case string:
+		return r.redactString(value), true
+	case []byte:
+		return []byte(r.redactString(string(value))), true
+	default:
+		return nil, false
+	}
+}
+
+func (r *Redactor) redactString(value string) string {
+	if !r.hash {
+		return Replacement
+	}
+	if isHash(value) {
+		// already redacted (e.g. a fixture loading a redacted dump)
+		return value
+	}
+	hash := sha256.Sum256([]byte(value))
+	return hashPrefix + hex.EncodeToString(hash[:])
+}
+
+func isHash(value string) bool {
+	if !strings.HasPrefix(value, hashPrefix) {
+		return false
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(value, hashPrefix))
+	return err == nil && len(decoded) == sha256.Size
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	metadataKeysFlag string
+	fieldsFlag       string
+	hashFlag         bool
+)
+
+// RegisterFlags registers the flags used to configure redaction
+func RegisterFlags() {
+	flag.StringVar(&metadataKeysFlag, "redact_metadata", "", "Comma separated list of metadata keys (glob patterns, e.g. authorization,cookie) to redact.")
+	flag.StringVar(&fieldsFlag, "redact_fields", "", "Comma separated list of fully qualified message fields (glob patterns, e.g. foo.v1.LoginRequest.password or *.password) to redact.")
+	flag.BoolVar(&hashFlag, "redact_hash", false, "Replace redacted values with a SHA-256 hash of the value instead of a fixed string so that equal values can still be matched.")
+}
+
+// FromFlags returns the Redactor configured by the flags or nil if no redaction is needed
+func FromFlags() (*Redactor, error) {
+	if metadataKeysFlag == "" && fieldsFlag == "" {
+		return nil, nil
+	}
+	return New(splitList(metadataKeysFlag), splitList(fieldsFlag), hashFlag)
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
@@ -0,0 +1,99 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func loginRequest(t *testing.T) *desc.MessageDescriptor {
+	credentials := builder.NewMessage("Credentials").
+		AddField(builder.NewField("password", builder.FieldTypeString())).
+		AddField(builder.NewField("pin", builder.FieldTypeInt32()))
+	request := builder.NewMessage("LoginRequest").
+		AddField(builder.NewField("username", builder.FieldTypeString())).
+		AddField(builder.NewField("credentials", builder.FieldTypeMessage(credentials)))
+	file, err := builder.NewFile("login.proto").SetPackageName("test").
+		AddMessage(credentials).AddMessage(request).Build()
+	require.NoError(t, err)
+	return file.FindMessage("test.LoginRequest")
+}
+
+func TestRedactor_Metadata(t *testing.T) {
+	r, err := New([]string{"Authorization", "x-*-token"}, nil, false)
+	require.NoError(t, err)
+
+	md := metadata.MD{
+		"authorization": []string{"Bearer secret"},
+		"x-api-token":   []string{"secret"},
+		"user-agent":    []string{"grpc-go"},
+	}
+	redacted := r.Metadata(md)
+	require.Equal(t, metadata.MD{
+		"authorization": []string{Replacement},
+		"x-api-token":   []string{Replacement},
+		"user-agent":    []string{"grpc-go"},
+	}, redacted)
+	require.Equal(t, []string{"Bearer secret"}, md.Get("authorization"), "original metadata must not be modified")
+
+	var nilRedactor *Redactor
+	require.Equal(t, md, nilRedactor.Metadata(md))
+}
+
+func TestRedactor_DumpedMessage(t *testing.T) {
+	md := loginRequest(t)
+	msg := dynamic.NewMessage(md)
+	msg.SetFieldByName("username", "alice")
+	credentials := dynamic.NewMessage(md.FindFieldByName("credentials").GetMessageType())
+	credentials.SetFieldByName("password", "hunter2")
+	credentials.SetFieldByName("pin", int32(1234))
+	msg.SetFieldByName("credentials", credentials)
+	raw, err := msg.Marshal()
+	require.NoError(t, err)
+
+	r, err := New(nil, []string{"*.password", "test.Credentials.pin"}, true)
+	require.NoError(t, err)
+	message := &internal.Message{RawMessage: raw}
+	require.NoError(t, r.DumpedMessage(message, msg))
+	require.NotEqual(t, raw, message.RawMessage)
+
+	redacted := dynamic.NewMessage(md)
+	require.NoError(t, redacted.Unmarshal(message.RawMessage))
+	require.Equal(t, "alice", redacted.GetFieldByName("username"))
+	redactedCredentials := redacted.GetFieldByName("credentials").(*dynamic.Message)
+	password := redactedCredentials.GetFieldByName("password").(string)
+	require.Regexp(t, "^sha256:[0-9a-f]{64}$", password)
+	require.Equal(t, int32(0), redactedCredentials.GetFieldByName("pin"))
+
+	// redacting an already redacted message must not change it
+	// so that fixtures can redact dumps which were recorded with redaction
+	require.False(t, r.Message(dynamic.NewMessage(md)))
+	reRedacted := &internal.Message{RawMessage: message.RawMessage}
+	require.NoError(t, r.DumpedMessage(reRedacted, redacted))
+	require.Equal(t, password, redacted.GetFieldByName("credentials").(*dynamic.Message).GetFieldByName("password"))
+
+	// messages which can't be decoded (or whose definitions aren't known) can't be safely redacted
+	undecoded := &internal.Message{RawMessage: raw, Message: "decoded"}
+	require.Error(t, r.DumpedMessage(undecoded, nil))
+	require.Empty(t, undecoded.RawMessage)
+	require.Nil(t, undecoded.Message)
+
+	unknown := &internal.Message{RawMessage: raw}
+	decoded, err := proto_decoder.NewDecoder(logrus.New()).Decode("/test.Login/Login", unknown)
+	require.NoError(t, err)
+	require.Error(t, r.DumpedMessage(unknown, decoded))
+	require.Empty(t, unknown.RawMessage)
+
+	metadataOnly, err := New([]string{"authorization"}, nil, false)
+	require.NoError(t, err)
+	unredacted := &internal.Message{RawMessage: raw}
+	require.NoError(t, metadataOnly.DumpedMessage(unredacted, nil))
+	require.Equal(t, raw, unredacted.RawMessage)
+}
@@ -0,0 +1,294 @@
+package rewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal/methodmatch"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// Direction is which messages and metadata of an RPC a rule rewrites
+type Direction string
+
+const (
+	// Request rewrites client messages and the request metadata sent to the server
+	Request Direction = "request"
+	// Response rewrites server messages and the response metadata sent to the client
+	Response Direction = "response"
+)
+
+// Rule describes how to rewrite the messages and metadata of RPCs matching its service and method.
+// Fields are referred to by dot separated paths of field names, JSON names or numbers
+// (e.g. user.id or 1.2) and paths through repeated messages apply to every element.
+type Rule struct {
+	// Glob patterns matched against the fully qualified service name (e.g. foo.v1.*)
+	// and the method name. Empty patterns match everything.
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+	// Rewrite requests, responses or both if empty
+	Direction Direction `json:"direction,omitempty"`
+
+	// Values (in their protobuf JSON form) to set fields to
+	Set map[string]json.RawMessage `json:"set,omitempty"`
+	// Regular expression replacements applied to string fields
+	Replace map[string]Replacement `json:"replace,omitempty"`
+	// Fields to clear
+	Delete []string `json:"delete,omitempty"`
+
+	// Metadata values to set (in the headers for responses)
+	SetMetadata map[string]string `json:"set_metadata,omitempty"`
+	// Glob patterns of metadata keys to remove
+	DeleteMetadata []string `json:"delete_metadata,omitempty"`
+}
+
+// Replacement replaces matches of Pattern with With (which can refer to submatches e.g. $1)
+type Replacement struct {
+	Pattern string `json:"pattern"`
+	With    string `json:"with"`
+}
+
+// Validate checks that the rule's patterns and settings are valid
+func (r Rule) Validate() error {
+	_, err := compile(r)
+	return err
+}
+
+// LoadRules reads a JSON array of Rules
+func LoadRules(rulesPath string) ([]Rule, error) {
+	var rules []Rule
+	err := methodmatch.LoadRules(rulesPath, "rewrite rule", &rules, func(i int) error {
+		return rules[i].Validate()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// compiledRule is a validated Rule with its field paths split and regular expressions compiled
+type compiledRule struct {
+	Rule
+	set     []fieldValue
+	replace []fieldReplacement
+	delete  [][]string
+}
+
+type fieldValue struct {
+	path  []string
+	value json.RawMessage
+}
+
+type fieldReplacement struct {
+	path    []string
+	pattern *regexp.Regexp
+	with    string
+}
+
+func compile(r Rule) (*compiledRule, error) {
+	if err := methodmatch.ValidatePatterns(append([]string{r.Service, r.Method}, r.DeleteMetadata...)...); err != nil {
+		return nil, err
+	}
+	switch r.Direction {
+	case "", Request, Response:
+	default:
+		return nil, fmt.Errorf("unknown direction %q", r.Direction)
+	}
+
+	c := &compiledRule{Rule: r}
+	for _, field := range sortedKeys(r.Set) {
+		c.set = append(c.set, fieldValue{
+			path:  strings.Split(field, "."),
+			value: r.Set[field],
+		})
+	}
+	for _, field := range sortedKeys(r.Replace) {
+		pattern, err := regexp.Compile(r.Replace[field].Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid replacement pattern for field %s", field)
+		}
+		c.replace = append(c.replace, fieldReplacement{
+			path:    strings.Split(field, "."),
+			pattern: pattern,
+			with:    r.Replace[field].With,
+		})
+	}
+	for _, field := range r.Delete {
+		c.delete = append(c.delete, strings.Split(field, "."))
+	}
+	return c, nil
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]json.RawMessage:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]Replacement:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *compiledRule) matches(fullMethod string) bool {
+	return methodmatch.Method(r.Service, r.Method, fullMethod)
+}
+
+func (r *compiledRule) rewritesMessages() bool {
+	return len(r.set) > 0 || len(r.replace) > 0 || len(r.delete) > 0
+}
+
+// rewriteMessage applies the field operations to the message in place
+func (r *compiledRule) rewriteMessage(message *dynamic.Message) error {
+	for _, set := range r.set {
+		err := walk(message, set.path, true, func(parent *dynamic.Message, field *desc.FieldDescriptor) error {
+			return setField(parent, field, set.value)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to set %s", strings.Join(set.path, "."))
+		}
+	}
+	for _, replace := range r.replace {
+		err := walk(message, replace.path, false, func(parent *dynamic.Message, field *desc.FieldDescriptor) error {
+			return replaceField(parent, field, replace.pattern, replace.with)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to replace %s", strings.Join(replace.path, "."))
+		}
+	}
+	for _, fieldPath := range r.delete {
+		err := walk(message, fieldPath, false, func(parent *dynamic.Message, field *desc.FieldDescriptor) error {
+			parent.ClearField(field)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to delete %s", strings.Join(fieldPath, "."))
+		}
+	}
+	return nil
+}
+
+// rewriteMetadata returns a copy of md with the metadata operations applied
+func (r *compiledRule) rewriteMetadata(md metadata.MD, set bool) metadata.MD {
+	rewritten := metadata.MD{}
+	for key, values := range md {
+		if !matchAny(r.DeleteMetadata, key) {
+			rewritten[key] = values
+		}
+	}
+	if set {
+		for key, value := range r.SetMetadata {
+			rewritten.Set(key, value)
+		}
+	}
+	return rewritten
+}
+
+// walk calls f with each message reached by following all but the last field of the path
+// and the last field. Missing nested messages are created if create is set.
+func walk(message *dynamic.Message, fieldPath []string, create bool, f func(*dynamic.Message, *desc.FieldDescriptor) error) error {
+	field := findField(message.GetMessageDescriptor(), fieldPath[0])
+	if field == nil {
+		return fmt.Errorf("%s has no field %s", message.GetMessageDescriptor().GetFullyQualifiedName(), fieldPath[0])
+	}
+	if len(fieldPath) == 1 {
+		return f(message, field)
+	}
+	if field.GetMessageType() == nil || field.IsMap() {
+		return fmt.Errorf("field %s is not a message", field.GetFullyQualifiedName())
+	}
+
+	if field.IsRepeated() {
+		for _, nested := range message.GetField(field).([]interface{}) {
+			if nested, ok := nested.(*dynamic.Message); ok {
+				if err := walk(nested, fieldPath[1:], create, f); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if !message.HasField(field) {
+		if !create {
+			return nil
+		}
+		message.SetField(field, dynamic.NewMessage(field.GetMessageType()))
+	}
+	nested, ok := message.GetField(field).(*dynamic.Message)
+	if !ok {
+		return fmt.Errorf("field %s is not a dynamic message", field.GetFullyQualifiedName())
+	}
+	return walk(nested, fieldPath[1:], create, f)
+}
+
+// findField finds a field by name, JSON name or number
+func findField(message *desc.MessageDescriptor, name string) *desc.FieldDescriptor {
+	for _, f := range message.GetFields() {
+		if f.GetName() == name || f.GetJSONName() == name || fmt.Sprint(f.GetNumber()) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// setField sets a field to a value in its protobuf JSON form
+// (so enums can be set by name, messages as objects etc.)
+func setField(message *dynamic.Message, field *desc.FieldDescriptor, value json.RawMessage) error {
+	object, err := json.Marshal(map[string]json.RawMessage{field.GetJSONName(): value})
+	if err != nil {
+		return err
+	}
+	parsed := dynamic.NewMessage(message.GetMessageDescriptor())
+	if err := parsed.UnmarshalJSON(object); err != nil {
+		return err
+	}
+	if !parsed.HasField(field) {
+		// the zero value
+		message.ClearField(field)
+		return nil
+	}
+	return message.TrySetField(field, parsed.GetField(field))
+}
+
+func replaceField(message *dynamic.Message, field *desc.FieldDescriptor, pattern *regexp.Regexp, with string) error {
+	if !message.HasField(field) {
+		return nil
+	}
+	switch value := message.GetField(field).(type) {
+	case string:
+		return message.TrySetField(field, pattern.ReplaceAllString(value, with))
+	case []interface{}:
+		replaced := make([]interface{}, len(value))
+		for i, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("field %s is not a string", field.GetFullyQualifiedName())
+			}
+			replaced[i] = pattern.ReplaceAllString(s, with)
+		}
+		return message.TrySetField(field, replaced)
+	default:
+		return fmt.Errorf("field %s is not a string", field.GetFullyQualifiedName())
+	}
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package rewrite
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func userRequest(t *testing.T) *desc.MessageDescriptor {
+	user := builder.NewMessage("User").
+		AddField(builder.NewField("id", builder.FieldTypeInt64())).
+		AddField(builder.NewField("email", builder.FieldTypeString()))
+	request := builder.NewMessage("Request").
+		AddField(builder.NewField("user", builder.FieldTypeMessage(user))).
+		AddField(builder.NewField("friends", builder.FieldTypeMessage(user)).SetRepeated()).
+		AddField(builder.NewField("debug", builder.FieldTypeBool()))
+	file, err := builder.NewFile("user.proto").SetPackageName("test").
+		AddMessage(user).AddMessage(request).Build()
+	require.NoError(t, err)
+	return file.FindMessage("test.Request")
+}
+
+type descriptorDecoder struct {
+	descriptor *desc.MessageDescriptor
+}
+
+func (d descriptorDecoder) Decode(_ string, message *internal.Message) (*dynamic.Message, error) {
+	decoded := dynamic.NewMessage(d.descriptor)
+	return decoded, decoded.Unmarshal(message.RawMessage)
+}
+
+type mockServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	received [][]byte
+	header   metadata.MD
+	trailer  metadata.MD
+}
+
+func (m *mockServerStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockServerStream) RecvMsg(msg interface{}) error {
+	*msg.(*[]byte) = m.received[0]
+	m.received = m.received[1:]
+	return nil
+}
+
+func (m *mockServerStream) SendHeader(md metadata.MD) error {
+	m.header = md
+	return nil
+}
+
+func (m *mockServerStream) SetTrailer(md metadata.MD) {
+	m.trailer = md
+}
+
+func TestRewriter(t *testing.T) {
+	descriptor := userRequest(t)
+	request := dynamic.NewMessage(descriptor)
+	require.NoError(t, request.UnmarshalJSON([]byte(`{
+		"friends": [{"id": 1, "email": "bob@example.com"}, {"id": 2, "email": "carol@example.com"}],
+		"debug": true
+	}`)))
+	raw, err := request.Marshal()
+	require.NoError(t, err)
+
+	var rule Rule
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"service": "test.*",
+		"direction": "request",
+		"set": {"user.id": "1234", "user.email": "alice@example.com"},
+		"replace": {"friends.email": {"pattern": "@(.*)$", "with": "@test.$1"}},
+		"delete": ["debug"],
+		"set_metadata": {"x-env": "test"},
+		"delete_metadata": ["authorization"]
+	}`), &rule))
+	ignored := Rule{Method: "Other", Delete: []string{"user"}}
+	rewriter, err := NewRewriter(logrus.New(), descriptorDecoder{descriptor}, []Rule{rule, ignored})
+	require.NoError(t, err)
+
+	ss := &mockServerStream{
+		ctx:      metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "secret", "user-agent", "test")),
+		received: [][]byte{raw},
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Get"}
+	err = rewriter.Intercept(nil, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		require.Equal(t, metadata.Pairs("user-agent", "test", "x-env", "test"), md)
+
+		var received []byte
+		require.NoError(t, ss.RecvMsg(&received))
+		rewritten := dynamic.NewMessage(descriptor)
+		require.NoError(t, rewritten.Unmarshal(received))
+		json, err := rewritten.MarshalJSON()
+		require.NoError(t, err)
+		require.JSONEq(t, `{
+			"user": {"id": "1234", "email": "alice@example.com"},
+			"friends": [{"id": "1", "email": "bob@test.example.com"}, {"id": "2", "email": "carol@test.example.com"}]
+		}`, string(json))
+
+		// responses aren't rewritten by request rules
+		require.NoError(t, ss.SendHeader(metadata.Pairs("authorization", "kept")))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, metadata.Pairs("authorization", "kept"), ss.header)
+
+	require.Error(t, Rule{Replace: map[string]Replacement{"email": {Pattern: "("}}}.Validate())
+	require.Error(t, Rule{Direction: "sideways"}.Validate())
+}
@@ -0,0 +1,161 @@
+package rewrite
+
+import (
+	"context"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Rewriter rewrites the messages and metadata of RPCs using all the matching rules in order
+type Rewriter struct {
+	rules   []*compiledRule
+	logger  logrus.FieldLogger
+	decoder proto_decoder.MessageDecoder
+}
+
+func NewRewriter(logger logrus.FieldLogger, decoder proto_decoder.MessageDecoder, rules []Rule) (*Rewriter, error) {
+	r := &Rewriter{
+		logger:  logger,
+		decoder: decoder,
+	}
+	for _, rule := range rules {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// Intercept implements a grpc.StreamServerInterceptor
+func (r *Rewriter) Intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	var requestRules, responseRules []*compiledRule
+	for _, rule := range r.rules {
+		if !rule.matches(info.FullMethod) {
+			continue
+		}
+		if rule.Direction != Response {
+			requestRules = append(requestRules, rule)
+		}
+		if rule.Direction != Request {
+			responseRules = append(responseRules, rule)
+		}
+	}
+	if len(requestRules) == 0 && len(responseRules) == 0 {
+		return handler(srv, ss)
+	}
+
+	ctx := ss.Context()
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(requestRules) > 0 {
+		for _, rule := range requestRules {
+			md = rule.rewriteMetadata(md, true)
+		}
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return handler(srv, &rewritingServerStream{
+		ServerStream:  ss,
+		ctx:           ctx,
+		rewriter:      r,
+		fullMethod:    info.FullMethod,
+		requestRules:  requestRules,
+		responseRules: responseRules,
+	})
+}
+
+// rewriteMessage applies the rules to an encoded message.
+// If the message can't be rewritten, it's logged and the message is returned unchanged.
+func (r *Rewriter) rewriteMessage(fullMethod string, origin internal.MessageOrigin, raw []byte, rules []*compiledRule) []byte {
+	var messageRules []*compiledRule
+	for _, rule := range rules {
+		if rule.rewritesMessages() {
+			messageRules = append(messageRules, rule)
+		}
+	}
+	if len(messageRules) == 0 {
+		return raw
+	}
+
+	logger := r.logger.WithField("method", fullMethod).WithField("origin", origin)
+	decoded, err := r.decoder.Decode(fullMethod, &internal.Message{
+		MessageOrigin: origin,
+		RawMessage:    raw,
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to decode message for rewriting")
+		return raw
+	}
+	for _, rule := range messageRules {
+		if err := rule.rewriteMessage(decoded); err != nil {
+			logger.WithError(err).Warn("Failed to rewrite message")
+		}
+	}
+	rewritten, err := decoded.Marshal()
+	if err != nil {
+		logger.WithError(err).Warn("Failed to encode rewritten message")
+		return raw
+	}
+	return rewritten
+}
+
+func rewriteResponseMetadata(md metadata.MD, rules []*compiledRule, set bool) metadata.MD {
+	for _, rule := range rules {
+		md = rule.rewriteMetadata(md, set)
+	}
+	return md
+}
+
+type rewritingServerStream struct {
+	grpc.ServerStream
+	ctx           context.Context
+	rewriter      *Rewriter
+	fullMethod    string
+	requestRules  []*compiledRule
+	responseRules []*compiledRule
+	headerSent    bool
+}
+
+func (s *rewritingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *rewritingServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if raw, ok := m.(*[]byte); ok {
+		*raw = s.rewriter.rewriteMessage(s.fullMethod, internal.ClientMessage, *raw, s.requestRules)
+	}
+	return nil
+}
+
+func (s *rewritingServerStream) SendMsg(m interface{}) error {
+	if !s.headerSent {
+		// make sure metadata is set even if the handler doesn't send any headers
+		s.headerSent = true
+		if err := s.ServerStream.SetHeader(rewriteResponseMetadata(nil, s.responseRules, true)); err != nil {
+			return err
+		}
+	}
+	if raw, ok := m.([]byte); ok {
+		m = s.rewriter.rewriteMessage(s.fullMethod, internal.ServerMessage, raw, s.responseRules)
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *rewritingServerStream) SetHeader(md metadata.MD) error {
+	return s.ServerStream.SetHeader(rewriteResponseMetadata(md, s.responseRules, true))
+}
+
+func (s *rewritingServerStream) SendHeader(md metadata.MD) error {
+	s.headerSent = true
+	return s.ServerStream.SendHeader(rewriteResponseMetadata(md, s.responseRules, true))
+}
+
+func (s *rewritingServerStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(rewriteResponseMetadata(md, s.responseRules, false))
+}
@@ -0,0 +1,74 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/bradleyjkemp/grpc-tools/internal/methodmatch"
+	"google.golang.org/grpc/metadata"
+)
+
+// Route forwards RPCs matching its service, method, authority and metadata to Destination
+type Route struct {
+	// Optional name recorded in dumps to identify the route
+	Name string `json:"name,omitempty"`
+	// Glob patterns matched against the fully qualified service name (e.g. payments.*),
+	// the method name and the :authority the RPC was sent to. Empty patterns match everything.
+	Service   string `json:"service,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Authority string `json:"authority,omitempty"`
+	// Glob patterns matched against the values of request metadata keys
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Address (host:port) of the upstream server to forward RPCs to
+	Destination string `json:"destination"`
+	// Connect to Destination without TLS even if the client used TLS (e.g. for a local dev server)
+	Plaintext bool `json:"plaintext,omitempty"`
+}
+
+// Validate checks that the route's patterns and destination are valid
+func (r Route) Validate() error {
+	if r.Destination == "" {
+		return fmt.Errorf("route has no destination")
+	}
+	if host, port, err := net.SplitHostPort(r.Destination); err != nil || host == "" || port == "" {
+		return fmt.Errorf("invalid destination %q: must be a host:port", r.Destination)
+	}
+	if err := methodmatch.ValidatePatterns(r.Service, r.Method, r.Authority); err != nil {
+		return err
+	}
+	return methodmatch.ValidateMetadata(r.Metadata)
+}
+
+// Matches returns whether an RPC should be forwarded using this route
+func (r Route) Matches(fullMethod string, md metadata.MD) bool {
+	if !methodmatch.Method(r.Service, r.Method, fullMethod) {
+		return false
+	}
+	if r.Authority != "" && !methodmatch.Any(r.Authority, md.Get(":authority")) {
+		return false
+	}
+	return methodmatch.Metadata(r.Metadata, md)
+}
+
+// Find returns the first route matching the RPC or nil if none match
+func Find(routes []Route, fullMethod string, md metadata.MD) *Route {
+	for i := range routes {
+		if routes[i].Matches(fullMethod, md) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// LoadRoutes reads a JSON array of Routes
+func LoadRoutes(routesPath string) ([]Route, error) {
+	var routes []Route
+	err := methodmatch.LoadRules(routesPath, "route", &routes, func(i int) error {
+		return routes[i].Validate()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
@@ -0,0 +1,34 @@
+package routing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestFind(t *testing.T) {
+	var routes []Route
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"name": "payments", "service": "payments.*", "destination": "localhost:9000", "plaintext": true},
+		{"name": "canary", "authority": "*.staging.example.com:*", "metadata": {"x-canary": "true"}, "destination": "canary.staging.example.com:443"},
+		{"method": "Get*", "authority": "api.example.com:443", "destination": "replica.example.com:443"}
+	]`), &routes))
+	for _, route := range routes {
+		require.NoError(t, route.Validate())
+	}
+
+	staging := metadata.Pairs(":authority", "api.staging.example.com:443")
+	require.Equal(t, "payments", Find(routes, "/payments.v1.Payments/Charge", staging).Name)
+	require.Nil(t, Find(routes, "/users.v1.Users/Get", staging))
+	canary := metadata.Join(staging, metadata.Pairs("x-canary", "true"))
+	require.Equal(t, "canary", Find(routes, "/users.v1.Users/Get", canary).Name)
+	production := metadata.Pairs(":authority", "api.example.com:443")
+	require.Equal(t, "replica.example.com:443", Find(routes, "/users.v1.Users/GetUser", production).Destination)
+	require.Nil(t, Find(routes, "/users.v1.Users/CreateUser", production))
+
+	require.Error(t, Route{}.Validate())
+	require.Error(t, Route{Destination: "localhost"}.Validate())
+	require.Error(t, Route{Service: "[", Destination: "localhost:9000"}.Validate())
+}
@@ -0,0 +1,69 @@
+package tlsmux
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal/ca"
+)
+
+// certificates decides which TLS connections can be intercepted
+// and picks the certificate to present during the handshake.
+type certificates struct {
+	// certs must all have their Leaf populated
+	certs     []tls.Certificate
+	authority *ca.Authority
+}
+
+func (c certificates) canIntercept(hostname string) bool {
+	if c.authority != nil {
+		// can sign a certificate for any hostname
+		return true
+	}
+	return c.find(hostname) != nil
+}
+
+// find returns the first certificate valid for the hostname
+func (c certificates) find(hostname string) *tls.Certificate {
+	for i := range c.certs {
+		if c.certs[i].Leaf.VerifyHostname(hostname) == nil {
+			return &c.certs[i]
+		}
+	}
+	return nil
+}
+
+func (c certificates) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	hostname := hello.ServerName
+	if hostname == "" {
+		// clients don't send SNI when connecting to an IP address
+		// so fall back to the destination of the CONNECT request
+		if proxConn, ok := hello.Conn.(proxiedConnection); ok {
+			hostname = trimPort(proxConn.OriginalDestination())
+		}
+	}
+
+	if hostname != "" {
+		if cert := c.find(hostname); cert != nil {
+			return cert, nil
+		}
+		if c.authority != nil {
+			return c.authority.CertificateFor(hostname)
+		}
+	}
+	if len(c.certs) > 0 {
+		// nothing better available so just try the first certificate
+		return &c.certs[0], nil
+	}
+	return nil, fmt.Errorf("no certificate available for %q", hostname)
+}
+
+func trimPort(destination string) string {
+	host, _, err := net.SplitHostPort(destination)
+	if err != nil {
+		return strings.Split(destination, ":")[0]
+	}
+	return host
+}
@@ -0,0 +1,80 @@
+package tlsmux
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// ConnectionStates records the TLS state of intercepted connections (keyed by
+// remote address) so that HTTP handlers can find the certificate a client presented.
+type ConnectionStates struct {
+	sync.Mutex
+	states map[string]tls.ConnectionState
+}
+
+func NewConnectionStates() *ConnectionStates {
+	return &ConnectionStates{
+		states: map[string]tls.ConnectionState{},
+	}
+}
+
+func (c *ConnectionStates) Get(remoteAddr string) (tls.ConnectionState, bool) {
+	c.Lock()
+	defer c.Unlock()
+	state, ok := c.states[remoteAddr]
+	return state, ok
+}
+
+func (c *ConnectionStates) set(remoteAddr string, state tls.ConnectionState) {
+	c.Lock()
+	defer c.Unlock()
+	c.states[remoteAddr] = state
+}
+
+func (c *ConnectionStates) remove(remoteAddr string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.states, remoteAddr)
+}
+
+type recordingListener struct {
+	net.Listener
+	states *ConnectionStates
+}
+
+func (l recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{
+		Conn:   conn.(*tls.Conn),
+		states: l.states,
+	}, nil
+}
+
+// recordingConn saves the connection state once the handshake has completed.
+// The handshake happens on the first Read so the state is always saved before
+// any request on the connection is handled.
+type recordingConn struct {
+	*tls.Conn
+	states   *ConnectionStates
+	recorded bool
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.recorded {
+		if state := c.Conn.ConnectionState(); state.HandshakeComplete {
+			c.states.set(c.RemoteAddr().String(), state)
+			c.recorded = true
+		}
+	}
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	c.states.remove(c.RemoteAddr().String())
+	return c.Conn.Close()
+}
@@ -2,14 +2,14 @@ package tlsmux
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"io"
 	"net"
 	"regexp"
-	"strings"
 	"sync"
 
+	"github.com/bradleyjkemp/grpc-tools/internal/ca"
 	"github.com/bradleyjkemp/grpc-tools/internal/peekconn"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,11 +22,16 @@ const (
 	http2NextProtoTLS = "h2"
 )
 
+// errClosed is returned by Accept once the listener is closed
+var errClosed = errors.New("tlsmux: use of closed listener")
+
 type tlsMuxListener struct {
 	net.Listener
 	close *sync.Once
-	conns <-chan net.Conn
-	errs  <-chan error
+	// closed is shared by both listeners so that closing either stops both accepting
+	closed chan struct{}
+	conns  <-chan net.Conn
+	errs   <-chan error
 }
 
 func (c *tlsMuxListener) Accept() (net.Conn, error) {
@@ -35,18 +40,31 @@ func (c *tlsMuxListener) Accept() (net.Conn, error) {
 		return conn, nil
 	case err := <-c.errs:
 		return nil, err
+	case <-c.closed:
+		return nil, errClosed
 	}
 }
 
 func (c *tlsMuxListener) Close() error {
 	var err error
 	c.close.Do(func() {
+		close(c.closed)
 		err = c.Listener.Close()
 	})
 	return err
 }
 
-func New(logger logrus.FieldLogger, listener net.Listener, cert *x509.Certificate, tlsCert tls.Certificate, keyLogWriter io.Writer) (net.Listener, net.Listener) {
+// New splits the listener into non-TLS and TLS listeners. TLS connections are intercepted
+// if one of tlsCerts is valid for the destination or if a certificate authority is provided
+// (authority may be nil) otherwise they are proxied to their original destination.
+// The Leaf field of each of tlsCerts must be populated.
+// If states is non-nil then clients are asked for a certificate and
+// the state of each intercepted TLS connection is recorded in it.
+func New(logger logrus.FieldLogger, listener net.Listener, tlsCerts []tls.Certificate, authority *ca.Authority, states *ConnectionStates, keyLogWriter io.Writer) (net.Listener, net.Listener) {
+	certs := certificates{
+		certs:     tlsCerts,
+		authority: authority,
+	}
 	var nonTLSConns = make(chan net.Conn, 128) // TODO decide on good buffer sizes for these channels
 	var nonTLSErrs = make(chan error, 128)
 	var tlsConns = make(chan net.Conn, 128)
@@ -69,7 +87,7 @@ func New(logger logrus.FieldLogger, listener net.Listener, cert *x509.Certificat
 					tlsErrs <- err
 				}
 				if isTLS {
-					handleTLSConn(logger, conn, cert, tlsConns)
+					handleTLSConn(logger, conn, certs, tlsConns)
 				} else {
 					nonTLSConns <- conn
 				}
@@ -78,35 +96,46 @@ func New(logger logrus.FieldLogger, listener net.Listener, cert *x509.Certificat
 		}
 	}()
 	closer := &sync.Once{}
+	closed := make(chan struct{})
 	nonTLSListener := nonHTTPBouncer{
 		logger,
 		&tlsMuxListener{
 			Listener: listener,
 			close:    closer,
+			closed:   closed,
 			conns:    nonTLSConns,
 		},
 		false,
 	}
 
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
-		KeyLogWriter: keyLogWriter,
+		GetCertificate: certs.getCertificate,
+		KeyLogWriter:   keyLogWriter,
 	}
 	// Support HTTP/2: https://golang.org/pkg/net/http/?m=all#Serve
 	tlsConfig.NextProtos = append(tlsConfig.NextProtos, http2NextProtoTLS)
+	if states != nil {
+		// the certificate is only recorded (not verified) so any client certificate is accepted
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	var interceptedTLS net.Listener = tls.NewListener(&tlsMuxListener{
+		Listener: listener,
+		close:    closer,
+		closed:   closed,
+		conns:    tlsConns,
+	}, tlsConfig)
+	if states != nil {
+		interceptedTLS = recordingListener{interceptedTLS, states}
+	}
 	tlsListener := nonHTTPBouncer{
 		logger,
-		tls.NewListener(&tlsMuxListener{
-			Listener: listener,
-			close:    closer,
-			conns:    tlsConns,
-		}, tlsConfig),
+		interceptedTLS,
 		true,
 	}
 	return nonTLSListener, tlsListener
 }
 
-func handleTLSConn(logger logrus.FieldLogger, conn net.Conn, cert *x509.Certificate, tlsConns chan net.Conn) {
+func handleTLSConn(logger logrus.FieldLogger, conn net.Conn, certs certificates, tlsConns chan net.Conn) {
 	logger.Debugf("Handling TLS connection %v", conn)
 
 	proxConn, ok := conn.(proxiedConnection)
@@ -125,8 +154,8 @@ func handleTLSConn(logger logrus.FieldLogger, conn net.Conn, cert *x509.Certific
 	logger.Debugf("Got TLS connection for destination %s", proxConn.OriginalDestination())
 
 	// trim the port suffix
-	originalHostname := strings.Split(proxConn.OriginalDestination(), ":")[0]
-	if cert != nil && cert.VerifyHostname(originalHostname) == nil {
+	originalHostname := trimPort(proxConn.OriginalDestination())
+	if certs.canIntercept(originalHostname) {
 		// the certificate we have allows us to intercept this connection
 		tlsConns <- conn
 		return
@@ -0,0 +1,271 @@
+package tui
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	reverse = "\x1b[7m"
+	dim     = "\x1b[2m"
+	reset   = "\x1b[0m"
+
+	help = "↑/↓ select  enter details  / search  n next  f filter  q quit"
+)
+
+func listHeight(height int) int {
+	if h := (height - 4) / 2; h > 1 {
+		return h
+	}
+	return 1
+}
+
+func detailHeight(height int) int {
+	if h := height - 4 - listHeight(height); h > 0 {
+		return h
+	}
+	return 0
+}
+
+// render returns the lines of the screen (which must be locked)
+func (u *UI) render(width, height int) []string {
+	lines := make([]string, 0, height)
+
+	header := fmt.Sprintf(" grpc-dump  %d RPCs", len(u.rpcs))
+	if u.filter != nil {
+		header += fmt.Sprintf(" (%d matching %s)", len(u.visible), u.filterExpression)
+	}
+	lines = append(lines, reverse+pad(header, width)+reset)
+
+	// the width left by the other columns and the spaces between them
+	methodWidth := width - 56
+	if methodWidth < 10 {
+		methodWidth = 10
+	}
+	row := func(start, method, status, duration, size string) string {
+		return pad(fmt.Sprintf("%-12s  %-*s  %-18s  %9s  %9s", start, methodWidth, truncate(method, methodWidth), truncate(status, 18), duration, size), width)
+	}
+	lines = append(lines, dim+row("TIME", "METHOD", "STATUS", "DURATION", "SIZE")+reset)
+
+	rows := listHeight(height)
+	if u.selected < u.listOffset {
+		u.listOffset = u.selected
+	}
+	if u.selected >= u.listOffset+rows {
+		u.listOffset = u.selected - rows + 1
+	}
+	for i := u.listOffset; i < u.listOffset+rows; i++ {
+		if i >= len(u.visible) {
+			lines = append(lines, "")
+			continue
+		}
+		rpc := u.visible[i]
+		line := row(startTime(rpc), "/"+rpc.Service+"/"+rpc.Method, statusCode(rpc.Status), formatDuration(duration(rpc)), formatSize(size(rpc)))
+		if i == u.selected {
+			line = reverse + line + reset
+		}
+		lines = append(lines, line)
+	}
+
+	title := "── details "
+	if u.details {
+		title += "(↑/↓ scroll, esc back) "
+	}
+	if n := width - len([]rune(title)); n > 0 {
+		title += strings.Repeat("─", n)
+	}
+	lines = append(lines, dim+pad(title, width)+reset)
+	details := u.detailLines()
+	for i := u.detailOffset; i < u.detailOffset+detailHeight(height); i++ {
+		if i < len(details) {
+			lines = append(lines, pad(details[i], width))
+		} else {
+			lines = append(lines, "")
+		}
+	}
+
+	footer := help
+	if u.status != "" {
+		footer += "  |  " + u.status
+	}
+	if u.prompt != nil {
+		footer = u.prompt.label + ": " + u.prompt.text + "_"
+	}
+	lines = append(lines, pad(footer, width))
+	return lines
+}
+
+func (u *UI) detailLines() []string {
+	if u.selected >= len(u.visible) {
+		return nil
+	}
+	return detailLines(u.visible[u.selected])
+}
+
+// detailLines describes everything recorded about an RPC
+func detailLines(rpc *internal.RPC) []string {
+	lines := []string{
+		fmt.Sprintf("Method:   /%s/%s", rpc.Service, rpc.Method),
+		"Status:   " + statusCode(rpc.Status),
+	}
+	if rpc.Status != nil && rpc.Status.Message != "" {
+		lines[1] += ": " + rpc.Status.Message
+	}
+	lines = append(lines,
+		fmt.Sprintf("Duration: %s", formatDuration(duration(rpc))),
+		fmt.Sprintf("Size:     %s", formatSize(size(rpc))),
+	)
+	if rpc.Route != nil {
+		route := rpc.Route.Destination
+		if rpc.Route.Name != "" {
+			route = rpc.Route.Name + " → " + route
+		}
+		lines = append(lines, "Route:    "+route)
+	}
+	if rpc.ClientCertificate != nil {
+		lines = append(lines, "Client:   "+rpc.ClientCertificate.Subject)
+	}
+
+	lines = append(lines, "", "Metadata:")
+	lines = append(lines, metadataLines(rpc.Metadata)...)
+	lines = append(lines, "", "Response headers:")
+	lines = append(lines, metadataLines(rpc.MetadataRespHeaders)...)
+	lines = append(lines, "", "Messages:")
+	lines = append(lines, messageLines(rpc.Messages)...)
+	lines = append(lines, "", "Response trailers:")
+	lines = append(lines, metadataLines(rpc.MetadataRespTrailers)...)
+
+	if rpc.Mirror != nil {
+		lines = append(lines, "", "Mirror:   "+rpc.Mirror.Destination, "  Status: "+statusCode(rpc.Mirror.Status))
+		switch {
+		case rpc.Mirror.Incomplete:
+			lines = append(lines, "  Not compared: client messages were dropped")
+		case len(rpc.Mirror.Differences) == 0:
+			lines = append(lines, "  Same response")
+		}
+		for _, difference := range rpc.Mirror.Differences {
+			lines = append(lines, "  Differs: "+difference)
+		}
+		lines = append(lines, messageLines(rpc.Mirror.Messages)...)
+	}
+	return lines
+}
+
+func metadataLines(md metadata.MD) []string {
+	if len(md) == 0 {
+		return []string{"  (none)"}
+	}
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("  %s: %s", key, strings.Join(md[key], ", ")))
+	}
+	return lines
+}
+
+func messageLines(messages []*internal.Message) []string {
+	if len(messages) == 0 {
+		return []string{"  (none)"}
+	}
+	var lines []string
+	for _, message := range messages {
+		direction := "→"
+		if message.MessageOrigin == internal.ServerMessage {
+			direction = "←"
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s %s (%s)", direction, message.MessageOrigin, message.Timestamp.Format("15:04:05.000"), formatSize(len(message.RawMessage))))
+
+		body := "raw: " + base64.StdEncoding.EncodeToString(message.RawMessage)
+		if message.Message != nil {
+			if decoded, err := json.MarshalIndent(message.Message, "", "  "); err == nil {
+				body = string(decoded)
+			}
+		}
+		for _, line := range strings.Split(body, "\n") {
+			lines = append(lines, "    "+line)
+		}
+	}
+	return lines
+}
+
+func startTime(rpc *internal.RPC) string {
+	if len(rpc.Messages) == 0 {
+		return ""
+	}
+	return rpc.Messages[0].Timestamp.Format("15:04:05.000")
+}
+
+// duration is the time between the first and last messages of an RPC
+// (i.e. the latency of a unary RPC) as the start and end of RPCs aren't recorded
+func duration(rpc *internal.RPC) time.Duration {
+	if len(rpc.Messages) < 2 {
+		return 0
+	}
+	return rpc.Messages[len(rpc.Messages)-1].Timestamp.Sub(rpc.Messages[0].Timestamp)
+}
+
+func size(rpc *internal.RPC) int {
+	total := 0
+	for _, message := range rpc.Messages {
+		total += len(message.RawMessage)
+	}
+	return total
+}
+
+func statusCode(s *internal.Status) string {
+	if s == nil {
+		return "OK"
+	}
+	return s.Code
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func formatSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
+
+// truncate shortens s to at most width characters
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// pad truncates or pads s to exactly width characters (so that styles cover the whole line)
+func pad(s string, width int) string {
+	s = strings.Replace(s, "\t", "  ", -1)
+	s = truncate(s, width)
+	if n := width - len([]rune(s)); n > 0 {
+		s += strings.Repeat(" ", n)
+	}
+	return s
+}
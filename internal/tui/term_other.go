@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+type terminal struct{}
+
+func openTerminal(*os.File) (*terminal, error) {
+	return nil, errors.New("the terminal UI isn't supported on this platform")
+}
+
+func (t *terminal) restore() {}
+
+func (t *terminal) size() (int, int, error) {
+	return 0, 0, errors.New("the terminal UI isn't supported on this platform")
+}
+
+func notifyResize(chan<- os.Signal) {}
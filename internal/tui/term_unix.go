@@ -0,0 +1,62 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package tui
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// terminal puts a terminal into raw mode (i.e. unbuffered input without echoing)
+type terminal struct {
+	fd    uintptr
+	saved syscall.Termios
+}
+
+func openTerminal(f *os.File) (*terminal, error) {
+	t := &terminal{fd: f.Fd()}
+	if err := ioctl(t.fd, ioctlReadTermios, unsafe.Pointer(&t.saved)); err != nil {
+		return nil, err
+	}
+	raw := t.saved
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(t.fd, ioctlWriteTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *terminal) restore() {
+	_ = ioctl(t.fd, ioctlWriteTermios, unsafe.Pointer(&t.saved))
+}
+
+// size returns the width and height of the terminal
+func (t *terminal) size() (int, int, error) {
+	var ws struct {
+		rows, cols, x, y uint16
+	}
+	if err := ioctl(t.fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.cols), int(ws.rows), nil
+}
+
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
+
+func ioctl(fd, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
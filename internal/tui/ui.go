@@ -0,0 +1,373 @@
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+)
+
+// the oldest RPCs are forgotten so that long sessions don't use unbounded memory
+const maxRPCs = 10000
+
+// FilterParser parses a filter expression into a function returning whether an RPC matches it
+type FilterParser func(expression string) (func(rpc *internal.RPC) bool, error)
+
+// UI is a terminal UI for browsing RPCs as they're dumped
+type UI struct {
+	parseFilter FilterParser
+
+	sync.Mutex
+	rpcs    []*internal.RPC
+	visible []*internal.RPC // the RPCs matching the filter
+	filter  func(rpc *internal.RPC) bool
+	// the expression the filter was parsed from
+	filterExpression string
+	search           string
+
+	selected     int
+	listOffset   int
+	detailOffset int
+	details      bool // whether the detail pane has focus
+	follow       bool // whether to keep the newest RPC selected
+
+	prompt *prompt
+	status string
+
+	redraw chan struct{}
+	done   chan struct{}
+	close  sync.Once
+}
+
+// prompt is the line being typed when searching or filtering
+type prompt struct {
+	label  string
+	text   string
+	submit func(text string)
+}
+
+func New(parseFilter FilterParser) *UI {
+	return &UI{
+		parseFilter: parseFilter,
+		follow:      true,
+		redraw:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// Add shows an RPC. It's safe to call concurrently (e.g. as a dump callback).
+func (u *UI) Add(rpc *internal.RPC) {
+	u.Lock()
+	u.rpcs = append(u.rpcs, rpc)
+	if len(u.rpcs) > maxRPCs {
+		oldest := u.rpcs[0]
+		u.rpcs = u.rpcs[1:]
+		if len(u.visible) > 0 && u.visible[0] == oldest {
+			u.visible = u.visible[1:]
+			u.moveSelection(-1)
+		}
+	}
+	if u.filter == nil || u.filter(rpc) {
+		u.visible = append(u.visible, rpc)
+		if u.follow {
+			u.selectRPC(len(u.visible) - 1)
+		}
+	}
+	u.Unlock()
+	u.requestRedraw()
+}
+
+// Write shows the last line written in the status bar (e.g. so that logs don't garble the UI)
+func (u *UI) Write(p []byte) (int, error) {
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	u.Lock()
+	u.status = lines[len(lines)-1]
+	u.Unlock()
+	u.requestRedraw()
+	return len(p), nil
+}
+
+// Close stops the UI (e.g. because the proxy has stopped)
+func (u *UI) Close() {
+	u.close.Do(func() {
+		close(u.done)
+	})
+}
+
+func (u *UI) requestRedraw() {
+	select {
+	case u.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// CheckTerminal returns an error if f isn't a terminal the UI can be shown on
+func CheckTerminal(f *os.File) error {
+	term, err := openTerminal(f)
+	if err != nil {
+		return fmt.Errorf("the terminal UI can't be shown: %v", err)
+	}
+	term.restore()
+	return nil
+}
+
+// Run shows the UI on the terminal until it's closed or the user quits.
+// The terminal is restored before it returns.
+func (u *UI) Run(in, out *os.File) error {
+	term, err := openTerminal(in)
+	if err != nil {
+		return err
+	}
+	defer term.restore()
+	// switch to the alternate screen (so the UI disappears on exit) and hide the cursor
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	input := make(chan []byte)
+	go func() {
+		for {
+			buf := make([]byte, 64)
+			n, err := in.Read(buf)
+			if err != nil {
+				return
+			}
+			input <- buf[:n]
+		}
+	}()
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+
+	for {
+		width, height, err := term.size()
+		if err != nil {
+			return err
+		}
+		u.draw(out, width, height)
+
+		select {
+		case b := <-input:
+			for _, k := range splitKeys(b) {
+				if !u.handleKey(k, height) {
+					return nil
+				}
+			}
+		case <-u.redraw:
+		case <-resize:
+		case <-u.done:
+			return nil
+		}
+	}
+}
+
+func (u *UI) draw(out io.Writer, width, height int) {
+	u.Lock()
+	lines := u.render(width, height)
+	u.Unlock()
+	buf := &bytes.Buffer{}
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	_, _ = out.Write(buf.Bytes())
+}
+
+type key string
+
+const (
+	keyUp        key = "\x1b[A"
+	keyDown      key = "\x1b[B"
+	keyPageUp    key = "\x1b[5~"
+	keyPageDown  key = "\x1b[6~"
+	keyHome      key = "\x1b[H"
+	keyEnd       key = "\x1b[F"
+	keyEnter     key = "\r"
+	keyTab       key = "\t"
+	keyEscape    key = "\x1b"
+	keyBackspace key = "\x7f"
+	keyCtrlC     key = "\x03"
+)
+
+// splitKeys splits terminal input into key presses (characters or escape sequences)
+func splitKeys(b []byte) []key {
+	var keys []key
+	for len(b) > 0 {
+		n := 1
+		switch {
+		case b[0] == 0x1b && len(b) > 2 && (b[1] == '[' || b[1] == 'O'):
+			// CSI (or SS3) sequence ended by a byte in the range @ to ~
+			n = 2
+			for n < len(b) && (b[n] < 0x40 || b[n] > 0x7e) {
+				n++
+			}
+			if n < len(b) {
+				n++
+			}
+			// normalise SS3 sequences (sent for the arrow keys in some modes)
+			if b[1] == 'O' {
+				keys = append(keys, key("\x1b["+string(b[2:n])))
+				b = b[n:]
+				continue
+			}
+		case b[0] >= utf8.RuneSelf:
+			_, n = utf8.DecodeRune(b)
+		}
+		keys = append(keys, key(b[:n]))
+		b = b[n:]
+	}
+	return keys
+}
+
+// handleKey updates the UI for a key press and returns false if the user quit
+func (u *UI) handleKey(k key, height int) bool {
+	defer u.requestRedraw()
+	u.Lock()
+	defer u.Unlock()
+	if u.prompt != nil {
+		u.handlePromptKey(k)
+		return true
+	}
+
+	page := listHeight(height)
+	if u.details {
+		page = detailHeight(height)
+	}
+	switch k {
+	case "q", keyCtrlC:
+		return false
+	case keyEnter, keyTab:
+		u.details = !u.details
+	case keyEscape:
+		u.details = false
+	case keyUp, "k":
+		u.scroll(-1)
+	case keyDown, "j":
+		u.scroll(1)
+	case keyPageUp:
+		u.scroll(-page)
+	case keyPageDown:
+		u.scroll(page)
+	case keyHome, "g":
+		u.scroll(-len(u.rpcs) - u.detailOffset)
+	case keyEnd, "G":
+		u.scroll(len(u.rpcs) + u.detailOffset + len(u.detailLines()))
+	case "/":
+		u.prompt = &prompt{label: "search", submit: func(text string) {
+			u.search = text
+			u.searchNext()
+		}}
+	case "n":
+		u.searchNext()
+	case "f":
+		u.prompt = &prompt{label: "filter", text: u.filterExpression, submit: u.setFilter}
+	}
+	return true
+}
+
+func (u *UI) handlePromptKey(k key) {
+	switch k {
+	case keyEnter:
+		p := u.prompt
+		u.prompt = nil
+		p.submit(p.text)
+	case keyEscape, keyCtrlC:
+		u.prompt = nil
+	case keyBackspace, "\b":
+		if _, size := utf8.DecodeLastRuneInString(u.prompt.text); size > 0 {
+			u.prompt.text = u.prompt.text[:len(u.prompt.text)-size]
+		}
+	default:
+		if r, _ := utf8.DecodeRuneInString(string(k)); len(k) > 0 && unicode.IsPrint(r) {
+			u.prompt.text += string(k)
+		}
+	}
+}
+
+// scroll moves the selection (or scrolls the detail pane if it has focus)
+func (u *UI) scroll(n int) {
+	if !u.details {
+		u.moveSelection(n)
+		return
+	}
+	u.detailOffset += n
+	if max := len(u.detailLines()) - 1; u.detailOffset > max {
+		u.detailOffset = max
+	}
+	if u.detailOffset < 0 {
+		u.detailOffset = 0
+	}
+}
+
+func (u *UI) moveSelection(n int) {
+	u.selectRPC(u.selected + n)
+}
+
+func (u *UI) selectRPC(i int) {
+	if i >= len(u.visible) {
+		i = len(u.visible) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i != u.selected {
+		u.detailOffset = 0
+	}
+	u.selected = i
+	u.follow = i >= len(u.visible)-1
+}
+
+func (u *UI) setFilter(expression string) {
+	var filter func(rpc *internal.RPC) bool
+	if strings.TrimSpace(expression) != "" {
+		var err error
+		filter, err = u.parseFilter(expression)
+		if err != nil {
+			u.status = fmt.Sprintf("Invalid filter: %v", err)
+			return
+		}
+	}
+	var selected *internal.RPC
+	if u.selected < len(u.visible) {
+		selected = u.visible[u.selected]
+	}
+
+	u.filter, u.filterExpression = filter, expression
+	u.visible = nil
+	for _, rpc := range u.rpcs {
+		if filter == nil || filter(rpc) {
+			u.visible = append(u.visible, rpc)
+		}
+	}
+	// keep the same RPC selected if it's still visible
+	u.selectRPC(len(u.visible) - 1)
+	for i, rpc := range u.visible {
+		if rpc == selected {
+			u.selectRPC(i)
+		}
+	}
+}
+
+// searchNext selects the next RPC whose details contain the search text
+func (u *UI) searchNext() {
+	if u.search == "" || len(u.visible) == 0 {
+		return
+	}
+	needle := strings.ToLower(u.search)
+	for i := 1; i <= len(u.visible); i++ {
+		j := (u.selected + i) % len(u.visible)
+		if strings.Contains(strings.ToLower(strings.Join(detailLines(u.visible[j]), "\n")), needle) {
+			u.selectRPC(j)
+			return
+		}
+	}
+	u.status = fmt.Sprintf("%q not found", u.search)
+}
@@ -0,0 +1,63 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitKeys(t *testing.T) {
+	require.Equal(t, []key{keyUp, "j", keyPageDown, keyDown, "é", keyEscape}, splitKeys([]byte("\x1b[Aj\x1b[6~\x1bOBé\x1b")))
+}
+
+func TestUI(t *testing.T) {
+	parseFilter := func(expression string) (func(rpc *internal.RPC) bool, error) {
+		return func(rpc *internal.RPC) bool {
+			return rpc.Method == expression
+		}, nil
+	}
+	u := New(parseFilter)
+	for _, method := range []string{"First", "Second", "Third"} {
+		u.Add(&internal.RPC{
+			Service:  "svc",
+			Method:   method,
+			Messages: []*internal.Message{{MessageOrigin: internal.ClientMessage, RawMessage: []byte(method)}},
+		})
+	}
+	press := func(keys ...key) {
+		for _, k := range keys {
+			require.True(t, u.handleKey(k, 20))
+		}
+	}
+	screen := func() string {
+		return strings.Join(u.render(100, 20), "\n")
+	}
+
+	// the newest RPC is followed
+	require.Equal(t, 2, u.selected)
+	require.Contains(t, screen(), "Method:   /svc/Third")
+	press(keyUp)
+	require.Contains(t, screen(), "Method:   /svc/Second")
+
+	// filtering keeps the selected RPC if it matches
+	press("f")
+	press(splitKeys([]byte("Second\r"))...)
+	require.Len(t, u.visible, 1)
+	require.Contains(t, screen(), "3 RPCs (1 matching Second)")
+	require.Contains(t, screen(), "Method:   /svc/Second")
+	press("f", keyBackspace, keyBackspace, keyBackspace, keyBackspace, keyBackspace, keyBackspace, keyEnter)
+	require.Len(t, u.visible, 3)
+	require.Equal(t, 1, u.selected)
+
+	// searching looks at the details of each RPC (including messages) and wraps around
+	press(splitKeys([]byte("/" + "raw: Rmly\r"))...) // base64 of "First"
+	require.Equal(t, 0, u.selected)
+	press("n")
+	require.Equal(t, 0, u.selected)
+	press(splitKeys([]byte("/nothing\r"))...)
+	require.Contains(t, screen(), `"nothing" not found`)
+
+	require.False(t, u.handleKey("q", 20))
+}
@@ -0,0 +1,172 @@
+package upstreamtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/pkg/errors"
+)
+
+// Config describes how to connect to an upstream server over TLS
+type Config struct {
+	// Client certificate and key to present to the server (for mutual TLS)
+	CertFile string `json:"cert,omitempty"`
+	KeyFile  string `json:"key,omitempty"`
+	// CA certificate to verify the server certificate with instead of the system roots
+	RootCAFile string `json:"ca,omitempty"`
+	// Overrides the server name used for verification and SNI
+	ServerName string `json:"server_name,omitempty"`
+}
+
+func (c Config) Load() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: c.ServerName,
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	if c.RootCAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.RootCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read root CA")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", c.RootCAFile)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// LoadConfigFile reads a JSON object mapping destination patterns to Configs
+func LoadConfigFile(configPath string) (map[string]Config, error) {
+	configFile, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer configFile.Close()
+
+	configs := map[string]Config{}
+	if err := json.NewDecoder(configFile).Decode(&configs); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", configPath)
+	}
+	return configs, nil
+}
+
+type destinationConfig struct {
+	pattern   string
+	tlsConfig *tls.Config
+}
+
+// Configs holds the TLS configuration to use for each upstream destination.
+type Configs struct {
+	exact    []destinationConfig
+	patterns []destinationConfig
+	fallback *tls.Config
+}
+
+// Add loads the TLS config for destinations matching the pattern. Patterns are either
+// a host, a host:port or a glob (e.g. *.example.com). The empty pattern or "*" matches all destinations.
+func (c *Configs) Add(pattern string, config Config) error {
+	tlsConfig, err := config.Load()
+	if err != nil {
+		return errors.Wrapf(err, "invalid upstream TLS config for %q", pattern)
+	}
+
+	switch {
+	case pattern == "" || pattern == "*":
+		c.fallback = tlsConfig
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid destination pattern %q", pattern)
+		}
+		c.patterns = append(c.patterns, destinationConfig{pattern, tlsConfig})
+	default:
+		c.exact = append(c.exact, destinationConfig{pattern, tlsConfig})
+	}
+	return nil
+}
+
+// For returns the TLS config for a destination (of the form host:port) or nil
+// if none has been configured. explicit is false if the config is the fallback
+// (so it doesn't imply the destination uses TLS).
+func (c *Configs) For(destination string) (tlsConfig *tls.Config, explicit bool) {
+	if c == nil {
+		return nil, false
+	}
+	host := strings.Split(destination, ":")[0]
+	for _, exact := range c.exact {
+		if exact.pattern == destination || exact.pattern == host {
+			return exact.tlsConfig, true
+		}
+	}
+	for _, glob := range c.patterns {
+		if matched, _ := path.Match(glob.pattern, destination); matched {
+			return glob.tlsConfig, true
+		}
+		if matched, _ := path.Match(glob.pattern, host); matched {
+			return glob.tlsConfig, true
+		}
+	}
+	return c.fallback, false
+}
+
+// Identities maps the certificates clients present to the proxy
+// to the client certificates to present to upstream servers.
+type Identities struct {
+	certs map[string]tls.Certificate
+}
+
+// Add maps an identity (either the SHA-256 fingerprint, subject or
+// common name of a client certificate) to the certificate and key in config.
+// Any other fields in config are ignored.
+func (i *Identities) Add(identity string, config Config) error {
+	clientCert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load client certificate for identity %q", identity)
+	}
+	if i.certs == nil {
+		i.certs = map[string]tls.Certificate{}
+	}
+	i.certs[strings.ToLower(identity)] = clientCert
+	return nil
+}
+
+// For finds the upstream client certificate mapped to a client's certificate,
+// returning the matched identity and a copy of base using that certificate.
+func (i *Identities) For(clientCert *x509.Certificate, base *tls.Config) (string, *tls.Config, bool) {
+	if i == nil || clientCert == nil {
+		return "", nil, false
+	}
+	for _, identity := range []string{
+		internal.NewCertificate(clientCert).Fingerprint,
+		clientCert.Subject.String(),
+		clientCert.Subject.CommonName,
+	} {
+		upstreamCert, ok := i.certs[strings.ToLower(identity)]
+		if !ok {
+			continue
+		}
+		tlsConfig := &tls.Config{}
+		if base != nil {
+			tlsConfig = base.Clone()
+		}
+		tlsConfig.Certificates = []tls.Certificate{upstreamCert}
+		return identity, tlsConfig, true
+	}
+	return "", nil, false
+}
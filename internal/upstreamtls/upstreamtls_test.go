@@ -0,0 +1,42 @@
+package upstreamtls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigsFor(t *testing.T) {
+	configs := &Configs{}
+	for _, pattern := range []string{"api.example.com:443", "db.internal", "*.staging.internal", ""} {
+		require.NoError(t, configs.Add(pattern, Config{ServerName: pattern}))
+	}
+
+	tests := []struct {
+		destination string
+		serverName  string
+		explicit    bool
+	}{
+		// exact host:port
+		{"api.example.com:443", "api.example.com:443", true},
+		// host only patterns match any port
+		{"db.internal:5432", "db.internal", true},
+		// globs match the host with or without the port
+		{"payments.staging.internal:443", "*.staging.internal", true},
+		// the fallback is used but doesn't imply TLS
+		{"api.example.com:8080", "", false},
+		{"localhost:80", "", false},
+	}
+	for _, test := range tests {
+		tlsConfig, explicit := configs.For(test.destination)
+		require.NotNil(t, tlsConfig, test.destination)
+		require.Equal(t, test.serverName, tlsConfig.ServerName, test.destination)
+		require.Equal(t, test.explicit, explicit, test.destination)
+	}
+
+	tlsConfig, explicit := (&Configs{}).For("localhost:80")
+	require.Nil(t, tlsConfig)
+	require.False(t, explicit)
+
+	require.Error(t, configs.Add("[", Config{}))
+}
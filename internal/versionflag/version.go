@@ -16,3 +16,8 @@ func init() {
 		flag.PrintDefaults()
 	}
 }
+
+// Version is the release version the binary was built as (empty for development builds)
+func Version() string {
+	return version
+}